@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBootStepTimeout = 5 * time.Minute
+	bootPollInterval       = 5 * time.Second
+
+	// Delay before a magic packet is sent again to a remote that is not up
+	// yet. It doubles after every retry.
+	bootWakeRetryInterval = 30 * time.Second
+)
+
+var errBootTimeout = errors.New("did not report healthy before the step timed out")
+
+// BootStep is a step of the boot sequence: remotes that are powered on together
+// once the remotes of all earlier steps are healthy. Remotes without a MAC
+// address are not woken, but still waited for.
+type BootStep struct {
+	Remotes []string
+
+	// How long to wait for the remotes to report healthy. Defaults to five
+	// minutes.
+	TimeoutSec int
+}
+
+// bootResult is the outcome of the boot sequence for one remote.
+type bootResult struct {
+	Step int
+	Host string
+
+	// Whether the remote was healthy before it was woken.
+	AlreadyUp bool
+
+	// How long it took for the remote to report healthy.
+	Duration time.Duration
+
+	Err error
+}
+
+func (r bootResult) String() string {
+	switch {
+	case r.Err == errAborted:
+		return fmt.Sprintf("step %d: %s: %v", r.Step, r.Host, r.Err)
+	case r.Err != nil:
+		return fmt.Sprintf("step %d: %s: failed: %v", r.Step, r.Host, r.Err)
+	case r.AlreadyUp:
+		return fmt.Sprintf("step %d: %s: already up", r.Step, r.Host)
+	default:
+		return fmt.Sprintf("step %d: %s: up after %s", r.Step, r.Host, r.Duration.Round(time.Second))
+	}
+}
+
+// bootSteps returns WebAdmin.BootSequence, or when it is empty, all remotes in
+// the reverse of their shutdown order. Remotes without a MAC address keep their
+// place so that the remotes depending on them wait for them.
+func (p *program) bootSteps() ([]BootStep, error) {
+	if len(p.Config.WebAdmin.BootSequence) > 0 {
+		return p.Config.WebAdmin.BootSequence, nil
+	}
+
+	levels, err := shutdownLevels(p.Config.WebAdmin.Remotes, p.Config.WebAdmin.Remotes)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]BootStep, 0, len(levels))
+	for i := len(levels) - 1; i >= 0; i-- {
+		var step BootStep
+		for _, remote := range levels[i] {
+			step.Remotes = append(step.Remotes, remote.Host)
+		}
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// bootStepTimeout returns how long to wait for the remotes of step.
+func bootStepTimeout(step BootStep) time.Duration {
+	if step.TimeoutSec > 0 {
+		return time.Duration(step.TimeoutSec) * time.Second
+	}
+
+	return defaultBootStepTimeout
+}
+
+// bootContext returns a context that ends once all steps could have timed out,
+// the deadline for the whole boot sequence.
+func bootContext(parent context.Context, steps []BootStep) (context.Context, context.CancelFunc) {
+	var timeout time.Duration
+	for _, step := range steps {
+		timeout += bootStepTimeout(step)
+	}
+
+	return context.WithTimeout(parent, timeout)
+}
+
+// runBootSequence powers on the remotes step by step and waits for every remote
+// of a step to report healthy before it starts the next one. Once a remote
+// failed, later steps are not attempted.
+func (p *program) runBootSequence(ctx context.Context, steps []BootStep) ([]bootResult, error) {
+	stepRemotes := make([][]Remote, len(steps))
+	for i, step := range steps {
+		for _, host := range step.Remotes {
+			remote, ok := p.remoteByHost(host)
+			if !ok {
+				return nil, errors.Errorf("unknown remote '%s' in boot sequence step %d", host, i+1)
+			}
+			stepRemotes[i] = append(stepRemotes[i], remote)
+		}
+	}
+
+	var results []bootResult
+	failed := false
+	for i, step := range steps {
+		remotes := stepRemotes[i]
+		stepResults := make([]bootResult, len(remotes))
+		for j, remote := range remotes {
+			stepResults[j] = bootResult{Step: i + 1, Host: remote.Host}
+		}
+
+		if failed {
+			for j := range stepResults {
+				stepResults[j].Err = errAborted
+			}
+			results = append(results, stepResults...)
+			continue
+		}
+
+		stepCtx, cancelFunc := context.WithTimeout(ctx, bootStepTimeout(step))
+		errs := p.fanOut(stepCtx, remotes, func(ctx context.Context, j int, r Remote) error {
+			return p.bootRemote(ctx, r, &stepResults[j])
+		})
+		cancelFunc()
+
+		for j := range stepResults {
+			if errs[j] != nil {
+				if errors.Is(errs[j], context.DeadlineExceeded) {
+					errs[j] = errBootTimeout
+				}
+				stepResults[j].Err = errs[j]
+				failed = true
+			}
+		}
+		results = append(results, stepResults...)
+	}
+
+	return results, nil
+}
+
+// bootRemote wakes the remote and waits until it reports healthy or ctx ends.
+// Magic packets are sent again with backoff, in case one got lost.
+func (p *program) bootRemote(ctx context.Context, r Remote, result *bootResult) error {
+	start := time.Now()
+	var nextWake time.Time
+	retryInterval := bootWakeRetryInterval
+	for attempt := 0; ; attempt++ {
+		nhr, err := p.FetchRemoteHealth(ctx, r)
+		if err == nil && nhr.Status == "online" {
+			result.AlreadyUp = attempt == 0
+			result.Duration = time.Since(start)
+			return nil
+		}
+
+		if now := time.Now(); r.MAC != "" && !now.Before(nextWake) {
+			if err = p.PoweronRemote(r); err != nil {
+				return err
+			}
+
+			if !nextWake.IsZero() {
+				retryInterval *= 2
+			}
+			nextWake = now.Add(retryInterval)
+		}
+
+		timer := time.NewTimer(bootPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// bootFailed returns whether any remote failed to boot.
+func bootFailed(results []bootResult) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeBootReport(report *bytes.Buffer, results []bootResult) {
+	for _, result := range results {
+		_, _ = fmt.Fprintln(report, result)
+	}
+}
+
+func validateBootSequence(remotes []Remote, steps []BootStep) error {
+	hosts := make(map[string]bool, len(remotes))
+	for _, remote := range remotes {
+		hosts[remote.Host] = true
+	}
+
+	seen := make(map[string]bool)
+	for i, step := range steps {
+		if len(step.Remotes) == 0 {
+			return errors.Errorf("no Remotes in boot sequence step %d", i+1)
+		}
+		if step.TimeoutSec < 0 {
+			return errors.Errorf("negative TimeoutSec in boot sequence step %d", i+1)
+		}
+
+		for _, host := range step.Remotes {
+			if !hosts[host] {
+				return errors.Errorf("unknown remote '%s' in boot sequence step %d", host, i+1)
+			}
+			if seen[host] {
+				return errors.Errorf("remote '%s' appears twice in the boot sequence", host)
+			}
+			seen[host] = true
+		}
+	}
+
+	return nil
+}
+
+func (p *program) webadminExecuteBootSequenceHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	steps, err := p.bootSteps()
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot determine boot sequence"))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	// The boot sequence goes on when the client disconnects, like other fleet
+	// operations.
+	ctx, cancelFunc := bootContext(p.t.Context(nil), steps)
+	defer cancelFunc()
+
+	var results []bootResult
+	results, err = p.runBootSequence(ctx, steps)
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot run boot sequence"))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	var report bytes.Buffer
+	writeBootReport(&report, results)
+	p.logFleetReport("boot-sequence", report.Bytes())
+	if bootFailed(results) {
+		_ = p.Logger.Error("boot sequence failed")
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Boot sequence failed.\n\n"))
+		_, _ = rw.Write(report.Bytes())
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("Boot sequence finished.\n\n"))
+	_, _ = rw.Write(report.Bytes())
+}
+
+// runBootSequenceCommand runs the boot sequence and prints a line per remote.
+func runBootSequenceCommand() error {
+	c, err := loadConfig()
+	if err != nil {
+		return errors.Wrap(err, "cannot load config")
+	}
+
+	p := &program{Logger: service.ConsoleLogger, Config: c}
+	if err = p.validateConfig(); err != nil {
+		return errors.Wrap(err, "invalid config")
+	}
+
+	var steps []BootStep
+	steps, err = p.bootSteps()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancelFunc := bootContext(context.Background(), steps)
+	defer cancelFunc()
+
+	var results []bootResult
+	results, err = p.runBootSequence(ctx, steps)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		fmt.Printf("  %s\n", result)
+	}
+
+	if bootFailed(results) {
+		return errors.New("boot sequence did not finish")
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBootSteps(t *testing.T) {
+	p := &program{}
+	p.Config.WebAdmin.Remotes = []Remote{
+		{Host: "switch", MAC: "aa:bb:cc:dd:ee:01"},
+		{Host: "nas", MAC: "aa:bb:cc:dd:ee:02", DependsOn: []string{"switch"}},
+		// Not woken, but still waited for between the nas and the web.
+		{Host: "proxy", DependsOn: []string{"nas"}},
+		{Host: "web", MAC: "aa:bb:cc:dd:ee:03", DependsOn: []string{"proxy"}},
+		{Host: "laptop", MAC: "aa:bb:cc:dd:ee:04"},
+	}
+
+	steps, err := p.bootSteps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(steps), "[{[switch] 0} {[nas] 0} {[proxy] 0} {[web laptop] 0}]"; got != want {
+		t.Fatalf("got steps %s, want %s", got, want)
+	}
+
+	sequence := []BootStep{{Remotes: []string{"nas"}, TimeoutSec: 60}}
+	p.Config.WebAdmin.BootSequence = sequence
+	steps, err = p.bootSteps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(steps) != fmt.Sprint(sequence) {
+		t.Fatalf("got steps %v, want the configured %v", steps, sequence)
+	}
+}
+
+func TestRunBootSequenceUnknownRemote(t *testing.T) {
+	p := &program{}
+	p.Config.WebAdmin.Remotes = []Remote{{Host: "nas"}}
+
+	results, err := p.runBootSequence(context.Background(), []BootStep{{Remotes: []string{"nas"}}, {Remotes: []string{"web"}}})
+	if err == nil {
+		t.Fatalf("got results %v and no error for an unknown remote", results)
+	}
+}
+
+func TestBootContext(t *testing.T) {
+	steps := []BootStep{{Remotes: []string{"nas"}, TimeoutSec: 60}, {Remotes: []string{"web"}}}
+	ctx, cancelFunc := bootContext(context.Background(), steps)
+	defer cancelFunc()
+
+	want := time.Minute + defaultBootStepTimeout
+	deadline, ok := ctx.Deadline()
+	if left := time.Until(deadline); !ok || left > want || left < want-10*time.Second {
+		t.Fatalf("got deadline in %s, want %s", left, want)
+	}
+}
+
+func TestValidateBootSequence(t *testing.T) {
+	remotes := []Remote{{Host: "nas"}, {Host: "web"}}
+	tests := []struct {
+		name    string
+		steps   []BootStep
+		wantErr bool
+	}{
+		{"valid", []BootStep{{Remotes: []string{"nas"}}, {Remotes: []string{"web"}, TimeoutSec: 30}}, false},
+		{"empty", nil, false},
+		{"empty step", []BootStep{{Remotes: []string{"nas"}}, {}}, true},
+		{"negative timeout", []BootStep{{Remotes: []string{"nas"}, TimeoutSec: -1}}, true},
+		{"unknown", []BootStep{{Remotes: []string{"db"}}}, true},
+		{"twice", []BootStep{{Remotes: []string{"nas"}}, {Remotes: []string{"web", "nas"}}}, true},
+	}
+
+	for _, test := range tests {
+		err := validateBootSequence(remotes, test.steps)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %t", test.name, err, test.wantErr)
+		}
+	}
+}
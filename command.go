@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCommandTimeout = time.Minute
+
+	// Maximum number of bytes of stdout and of stderr returned to the controller.
+	maxCommandOutputSize = 1 << 20
+)
+
+// NamedCommand is a fixed maintenance command that controllers may run on this
+// node by name.
+type NamedCommand struct {
+	Argv []string
+	Dir  string
+
+	// Extra environment variables as KEY=value, on top of the environment of
+	// the service.
+	Env []string
+
+	// Defaults to one minute.
+	TimeoutSec int
+
+	// Scopes of which an authorized key needs at least one to run the command.
+	// Defaults to "command:<name>".
+	Scopes []string
+}
+
+type nodeCommandResponse struct {
+	ExitCode int    `json:"ExitCode"`
+	TimedOut bool   `json:"TimedOut"`
+	Stdout   string `json:"Stdout"`
+	Stderr   string `json:"Stderr"`
+}
+
+func commandActionType(name string) string {
+	return "command:" + name
+}
+
+func (c NamedCommand) scopes(name string) []string {
+	if len(c.Scopes) > 0 {
+		return c.Scopes
+	}
+
+	return []string{commandActionType(name)}
+}
+
+// run executes the command and captures its output. A non-zero exit code is not
+// an error; failing to start the command is.
+func (c NamedCommand) run() (nodeCommandResponse, error) {
+	timeout := defaultCommandTimeout
+	if c.TimeoutSec > 0 {
+		timeout = time.Duration(c.TimeoutSec) * time.Second
+	}
+
+	return runCommand(c.Argv, c.Dir, c.Env, timeout, maxCommandOutputSize)
+}
+
+// runCommand runs argv and keeps up to outputLimit bytes of both stdout and
+// stderr. The command is killed once timeout has passed.
+func runCommand(argv []string, dir string, env []string, timeout time.Duration, outputLimit int) (nodeCommandResponse, error) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), timeout)
+	defer cancelFunc()
+
+	stdout := &limitedBuffer{limit: outputLimit}
+	stderr := &limitedBuffer{limit: outputLimit}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	resp := nodeCommandResponse{
+		TimedOut: ctx.Err() == context.DeadlineExceeded,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+	}
+	if err != nil {
+		ee, ok := err.(*exec.ExitError)
+		if !ok {
+			return resp, errors.Wrap(err, "cannot run command")
+		}
+
+		resp.ExitCode = ee.ExitCode()
+	}
+
+	return resp, nil
+}
+
+func (p *program) commandNames() []string {
+	names := make([]string, 0, len(p.Config.Node.Commands))
+	for name := range p.Config.Node.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// limitedBuffer keeps the first limit bytes written to it and drops the rest.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+
+		return len(p), nil
+	}
+
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n[output truncated]"
+	}
+
+	return b.buf.String()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestNodeExecuteCommandHandlerEscapedName(t *testing.T) {
+	name := "disk usage?all=1#%"
+	p := newTestNode(t)
+	p.Config.Node.Commands = map[string]NamedCommand{name: {Argv: []string{"echo", "ok"}}}
+
+	req, _, err := p.newNodeRequest(context.Background(), Remote{Host: "127.0.0.1"}, "/node/execute/command/disk%20usage%3Fall=1%23%25", &nodeCommandAction{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.RawQuery != "" || req.URL.Fragment != "" {
+		t.Fatalf("command name leaked out of the path: %s", req.URL)
+	}
+
+	rec := serveRequest(p.nodeExecuteCommandHandler, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+
+	var ncr nodeCommandResponse
+	if err = json.Unmarshal(rec.Body.Bytes(), &ncr); err != nil {
+		t.Fatal(err)
+	}
+	if ncr.Stdout != "ok\n" {
+		t.Fatalf("got stdout %q, want %q", ncr.Stdout, "ok\n")
+	}
+}
+
+func TestNamedCommandRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  NamedCommand
+		exitCode int
+		timedOut bool
+		stdout   string
+	}{
+		{"success", NamedCommand{Argv: []string{"echo", "ok"}}, 0, false, "ok\n"},
+		{"exit code", NamedCommand{Argv: []string{"sh", "-c", "exit 3"}}, 3, false, ""},
+		{"env", NamedCommand{Argv: []string{"sh", "-c", "echo $GREETING"}, Env: []string{"GREETING=hi"}}, 0, false, "hi\n"},
+		{"timeout", NamedCommand{Argv: []string{"sleep", "5"}, TimeoutSec: 1}, -1, true, ""},
+	}
+
+	for _, tt := range tests {
+		resp, err := tt.command.run()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if resp.ExitCode != tt.exitCode || resp.TimedOut != tt.timedOut || resp.Stdout != tt.stdout {
+			t.Errorf("%s: got %+v", tt.name, resp)
+		}
+	}
+}
+
+func TestNamedCommandScopes(t *testing.T) {
+	if got := (NamedCommand{}).scopes("backup"); len(got) != 1 || got[0] != "command:backup" {
+		t.Errorf("got %v, want [command:backup]", got)
+	}
+
+	own := NamedCommand{Scopes: []string{"maintenance"}}
+	if got := own.scopes("backup"); len(got) != 1 || got[0] != "maintenance" {
+		t.Errorf("got %v, want [maintenance]", got)
+	}
+}
@@ -1,11 +1,10 @@
 package main
 
 import (
-	"crypto/rsa"
+	"crypto"
+	"crypto/tls"
 	"encoding/json"
-	"io/fs"
 	"os"
-	"path/filepath"
 
 	"github.com/pkg/errors"
 )
@@ -23,13 +22,74 @@ type Config struct {
 		UriKey   string
 		Password string
 		Remotes  []Remote
+
+		// Rules that run power actions on remotes at scheduled times.
+		Schedules []ScheduleRule
+
+		// Number of remotes contacted at the same time. Defaults to 8.
+		MaxConcurrency int
+
+		// Order in which to power on the remotes. Defaults to the remotes with a
+		// MAC address in the reverse of their shutdown order.
+		BootSequence []BootStep
+
+		// What to do when a remote fails while powering off all remotes and
+		// self: "abort" (default), "continue" or "continue-keep-self".
+		PoweroffAllPolicy string
+
+		// Deadline for an operation on all remotes, like loading the dashboard
+		// or rebooting all remotes. Defaults to two minutes.
+		FleetTimeoutSec int
+
+		// Deadline for powering down remotes in dependency order, which waits
+		// for every level to go offline before the next. Defaults to ten
+		// minutes.
+		ShutdownTimeoutSec int
+	}
+
+	Node struct {
+		// Host names and addresses under which remotes reach this node. Signed
+		// requests must name one of them as their target. When empty, the Host
+		// header of the request is used instead.
+		Hostnames []string
+
+		// Reject node requests from clients that do not present a TLS client
+		// certificate for the key that signed the request.
+		RequireClientCertificate bool
+
+		// Commands that controllers may run through /node/execute/command/<name>.
+		Commands map[string]NamedCommand
+
+		// Hooks that run in order before power actions of this node.
+		PrePowerHooks []PowerHook
+
+		// How power actions are executed: "poweroff" (the default), "systemctl",
+		// "shutdown", "logind" or "dry-run".
+		PowerExecutor string
+
+		// Delay and message passed to shutdown by the "shutdown" executor.
+		ShutdownDelayMin int
+		ShutdownMessage  string
+
+		// Power off this node after it has been idle for a while.
+		Idle IdleConfig
 	}
 
 	// Public keys of hosts that can connect to this host.
-	authorizedKeys []*rsa.PublicKey
+	authorizedKeys *keyring
+
+	// Latest revocation list, which overrides authorizedKeys.
+	revocations *revocationStore
 
 	// Private key used to sign requests originating from this host.
-	selfPrivateKey *rsa.PrivateKey
+	selfPrivateKey crypto.Signer
+
+	// Key ID of selfPrivateKey, sent along with every signed request.
+	selfKeyID string
+
+	// Self-signed certificate for selfPrivateKey, used for both serving and
+	// connecting to remotes over TLS.
+	selfCertificate tls.Certificate
 }
 
 func loadConfig() (c Config, err error) {
@@ -48,7 +108,7 @@ func loadConfig() (c Config, err error) {
 		return
 	}
 
-	var selfKey *rsa.PrivateKey
+	var selfKey crypto.Signer
 	selfKey, err = loadSelfKey()
 	if err != nil {
 		err = errors.Wrap(err, "cannot load self key")
@@ -56,29 +116,37 @@ func loadConfig() (c Config, err error) {
 	}
 	c.selfPrivateKey = selfKey
 
-	err = filepath.Walk(authorizedKeysDirName, func(path string, info fs.FileInfo, err2 error) error {
-		if err2 != nil {
-			return err2
-		}
+	c.selfKeyID, err = keyFingerprint(selfKey.Public())
+	if err != nil {
+		err = errors.Wrap(err, "cannot determine self key ID")
+		return
+	}
 
-		if info.IsDir() {
-			return nil
-		}
+	c.selfCertificate, err = newSelfCertificate(c)
+	if err != nil {
+		err = errors.Wrap(err, "cannot create self certificate")
+		return
+	}
 
-		var publicKey *rsa.PublicKey
-		publicKey, err2 = loadPublicKey(path)
-		if err2 != nil {
-			return errors.Wrapf(err2, "cannot read key at '%s'", path)
-		}
+	c.authorizedKeys, err = loadAuthorizedKeys()
+	if err != nil {
+		err = errors.Wrap(err, "cannot load authorized keys")
+		return
+	}
 
-		c.authorizedKeys = append(c.authorizedKeys, publicKey)
-		return nil
-	})
+	c.revocations, err = loadRevocations()
 	if err != nil {
-		err = errors.Wrapf(err, "cannot walk directory '%s'", authorizedKeysDirName)
+		err = errors.Wrap(err, "cannot load revocation list")
 		return
 	}
 
+	for _, keyID := range c.revocations.revokedKeyIDs() {
+		if err = c.authorizedKeys.Retire(keyID); err != nil {
+			err = errors.Wrap(err, "cannot retire revoked key")
+			return
+		}
+	}
+
 	return
 }
 
@@ -109,14 +177,15 @@ func writeConfig(c Config) (err error) {
 	return
 }
 
-func addRemote(host string) error {
+func addRemote(host string, keyID string) error {
 	c, err := loadConfig()
 	if err != nil {
 		return errors.Wrap(err, "cannot load config")
 	}
 
 	c.WebAdmin.Remotes = append(c.WebAdmin.Remotes, Remote{
-		Host: host,
+		Host:  host,
+		KeyID: keyID,
 	})
 
 	if err = writeConfig(c); err != nil {
@@ -125,3 +194,28 @@ func addRemote(host string) error {
 
 	return nil
 }
+
+// setRemoteKeyID pins keyID for the remote with the given host, adding the
+// remote if it is not configured yet.
+func setRemoteKeyID(c Config, host string, keyID string) error {
+	found := false
+	for i := range c.WebAdmin.Remotes {
+		if c.WebAdmin.Remotes[i].Host == host {
+			c.WebAdmin.Remotes[i].KeyID = keyID
+			found = true
+		}
+	}
+
+	if !found {
+		c.WebAdmin.Remotes = append(c.WebAdmin.Remotes, Remote{
+			Host:  host,
+			KeyID: keyID,
+		})
+	}
+
+	if err := writeConfig(c); err != nil {
+		return errors.Wrap(err, "cannot write config")
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week. Fields accept *, numbers, ranges like 1-5, steps
+// like */15 or 8-18/2, and comma separated lists of those. Day of week 0 and 7
+// are both Sunday.
+type cronSchedule struct {
+	minute, hour, dom, month, dow []bool
+
+	// Like cron, a day matches either day field when both are restricted.
+	domAny, dowAny bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func parseCron(expr string) (cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return cronSchedule{}, errors.Errorf("cron expression '%s' does not have 5 fields", expr)
+	}
+
+	sets := make([][]bool, len(cronFields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return cronSchedule{}, errors.Wrapf(err, "invalid %s in cron expression '%s'", cronFields[i].name, expr)
+		}
+		sets[i] = set
+	}
+
+	// Sunday may be written as 0 or 7.
+	sets[4][0] = sets[4][0] || sets[4][7]
+
+	return cronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(s string, f cronField) ([]bool, error) {
+	set := make([]bool, f.max+1)
+	for _, part := range strings.Split(s, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, errors.Errorf("invalid step in '%s'", part)
+			}
+			rangePart = part[:i]
+		}
+
+		lo, hi := f.min, f.max
+		if rangePart != "*" {
+			var err error
+			if i := strings.Index(rangePart, "-"); i >= 0 {
+				lo, err = strconv.Atoi(rangePart[:i])
+				if err == nil {
+					hi, err = strconv.Atoi(rangePart[i+1:])
+				}
+			} else {
+				lo, err = strconv.Atoi(rangePart)
+				hi = lo
+				if strings.Contains(part, "/") {
+					hi = f.max
+				}
+			}
+			if err != nil {
+				return nil, errors.Errorf("invalid value in '%s'", part)
+			}
+		}
+
+		if lo < f.min || hi > f.max || lo > hi {
+			return nil, errors.Errorf("'%s' is out of range %d-%d", part, f.min, f.max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+
+	return set, nil
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom[t.Day()]
+	dow := s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Next returns the first time after the given time that matches the schedule,
+// in the location of after. It returns the zero time if nothing matches within
+// five years, like for February 30. Times skipped by a daylight saving time
+// change do not fire that day, and repeated times fire once.
+func (s cronSchedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute()+1, 0, 0, loc)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case !s.month[int(t.Month())]:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !s.hour[t.Hour()]:
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !s.minute[t.Minute()]:
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+
+		// Around daylight saving time changes a wall clock time can map to an
+		// earlier instant. Always move forward.
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+const cronTestLayout = "2006-01-02 15:04 MST"
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loc
+}
+
+// cronNextN returns the next n times the schedule fires after the given time.
+func cronNextN(t *testing.T, expr string, after time.Time, n int) []string {
+	t.Helper()
+
+	s, err := parseCron(expr)
+	if err != nil {
+		t.Fatalf("%q: %v", expr, err)
+	}
+
+	var times []string
+	for i := 0; i < n; i++ {
+		after = s.Next(after)
+		if after.IsZero() {
+			break
+		}
+		times = append(times, after.Format(cronTestLayout))
+	}
+
+	return times
+}
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday.
+	after := time.Date(2026, 4, 15, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"* * * * *", []string{"2026-04-15 10:08 UTC", "2026-04-15 10:09 UTC", "2026-04-15 10:10 UTC"}},
+		{"0 23 * * *", []string{"2026-04-15 23:00 UTC", "2026-04-16 23:00 UTC", "2026-04-17 23:00 UTC"}},
+
+		// Steps.
+		{"*/15 * * * *", []string{"2026-04-15 10:15 UTC", "2026-04-15 10:30 UTC", "2026-04-15 10:45 UTC", "2026-04-15 11:00 UTC"}},
+		{"0 8-18/4 * * *", []string{"2026-04-15 12:00 UTC", "2026-04-15 16:00 UTC", "2026-04-16 08:00 UTC"}},
+		{"10/20 * * * *", []string{"2026-04-15 10:10 UTC", "2026-04-15 10:30 UTC", "2026-04-15 10:50 UTC", "2026-04-15 11:10 UTC"}},
+
+		// Ranges and lists.
+		{"0 9 * * 1-5", []string{"2026-04-16 09:00 UTC", "2026-04-17 09:00 UTC", "2026-04-20 09:00 UTC"}},
+		{"5,55 10,12 * * *", []string{"2026-04-15 10:55 UTC", "2026-04-15 12:05 UTC", "2026-04-15 12:55 UTC", "2026-04-16 10:05 UTC"}},
+		{"0 0 * 1,6-7 *", []string{"2026-06-01 00:00 UTC", "2026-06-02 00:00 UTC"}},
+
+		// Sunday is 0 and 7.
+		{"0 0 * * 7", []string{"2026-04-19 00:00 UTC", "2026-04-26 00:00 UTC"}},
+		{"0 0 * * 0", []string{"2026-04-19 00:00 UTC", "2026-04-26 00:00 UTC"}},
+
+		// With both day fields restricted either may match.
+		{"0 0 1 * 5", []string{"2026-04-17 00:00 UTC", "2026-04-24 00:00 UTC", "2026-05-01 00:00 UTC", "2026-05-08 00:00 UTC"}},
+		// With one of them restricted only that one counts.
+		{"0 0 13 * *", []string{"2026-05-13 00:00 UTC", "2026-06-13 00:00 UTC"}},
+		{"0 0 * * 5", []string{"2026-04-17 00:00 UTC", "2026-04-24 00:00 UTC"}},
+
+		// Month and year rollover, and months without the day.
+		{"0 12 31 * *", []string{"2026-05-31 12:00 UTC", "2026-07-31 12:00 UTC", "2026-08-31 12:00 UTC", "2026-10-31 12:00 UTC"}},
+		{"0 0 1 1 *", []string{"2027-01-01 00:00 UTC", "2028-01-01 00:00 UTC"}},
+		{"0 0 29 2 *", []string{"2028-02-29 00:00 UTC", "2032-02-29 00:00 UTC"}},
+
+		// Never matches.
+		{"0 0 30 2 *", nil},
+	}
+
+	for _, test := range tests {
+		got := cronNextN(t, test.expr, after, len(test.want))
+		if len(got) != len(test.want) {
+			t.Errorf("%q: got %v, want %v", test.expr, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%q: got %v, want %v", test.expr, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCronNextDaylightSaving(t *testing.T) {
+	ams := mustLoadLocation(t, "Europe/Amsterdam")
+	tests := []struct {
+		name  string
+		expr  string
+		after time.Time
+		want  []string
+	}{
+		// 02:00 to 03:00 does not exist on 2026-03-29, so that run is skipped.
+		{"spring forward", "30 2 * * *", time.Date(2026, 3, 28, 12, 0, 0, 0, ams), []string{"2026-03-30 02:30 CEST", "2026-03-31 02:30 CEST"}},
+		{"spring forward hourly", "0 * * * *", time.Date(2026, 3, 29, 0, 30, 0, 0, ams), []string{"2026-03-29 01:00 CET", "2026-03-29 03:00 CEST", "2026-03-29 04:00 CEST"}},
+
+		// 02:00 to 03:00 happens twice on 2026-10-25, but runs only once.
+		{"fall back", "30 2 * * *", time.Date(2026, 10, 24, 12, 0, 0, 0, ams), []string{"2026-10-25 02:30 CET", "2026-10-26 02:30 CET"}},
+	}
+
+	for _, test := range tests {
+		got := cronNextN(t, test.expr, test.after, len(test.want))
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCronNextMovesForward(t *testing.T) {
+	ams := mustLoadLocation(t, "Europe/Amsterdam")
+	s, err := parseCron("*/15 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, start := range []time.Time{time.Date(2026, 3, 28, 22, 0, 0, 0, ams), time.Date(2026, 10, 24, 22, 0, 0, 0, ams)} {
+		after := start
+		for i := 0; i < 24*4; i++ {
+			next := s.Next(after)
+			if !next.After(after) {
+				t.Fatalf("Next(%s) = %s, not after it", after, next)
+			}
+			after = next
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"a * * * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"1-x * * * *",
+		"1,,2 * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q: got no error", expr)
+		}
+	}
+}
@@ -2,17 +2,27 @@ package main
 
 import (
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
+	"fmt"
 	"os"
 
 	"github.com/pkg/errors"
 )
 
-func loadSelfKey() (*rsa.PrivateKey, error) {
+const (
+	keyTypeEd25519 = "ed25519"
+	keyTypeRSA     = "rsa"
+
+	defaultKeyType = keyTypeEd25519
+)
+
+func loadSelfKey() (crypto.Signer, error) {
 	privKeyPath := selfKeyDirName + string(os.PathSeparator) + selfPrivKeyName
 	privateKey, err := loadPrivateKey(privKeyPath)
 	if err != nil {
@@ -20,17 +30,21 @@ func loadSelfKey() (*rsa.PrivateKey, error) {
 	}
 
 	pubKeyPath := selfKeyDirName + string(os.PathSeparator) + selfPubKeyName
-	var publicKey *rsa.PublicKey
+	var publicKey crypto.PublicKey
 	publicKey, err = loadPublicKey(pubKeyPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot load public key")
 	}
 
-	privateKey.PublicKey = *publicKey
+	if !publicKeysEqual(privateKey.Public(), publicKey) {
+		return nil, errors.Errorf("public key '%s' does not belong to private key '%s'", pubKeyPath, privKeyPath)
+	}
+
 	return privateKey, nil
 }
 
-func loadPrivateKey(privKeyPath string) (*rsa.PrivateKey, error) {
+// loadPrivateKey reads a PKCS#1 RSA key or a PKCS#8 RSA or Ed25519 key.
+func loadPrivateKey(privKeyPath string) (crypto.Signer, error) {
 	privateKeyPem, err := os.ReadFile(privKeyPath)
 	if err != nil {
 		return nil, errors.Wrapf(err, "cannot open private key file '%s'", privKeyPath)
@@ -40,43 +54,207 @@ func loadPrivateKey(privKeyPath string) (*rsa.PrivateKey, error) {
 	if privateKeyBlock == nil {
 		return nil, errors.Errorf("cannot decode private key file '%s'", privKeyPath)
 	}
-	if privateKeyBlock.Type != "RSA PRIVATE KEY" {
-		return nil, errors.Errorf("expected private key type to be 'RSA PRIVATE KEY' but was '%s'", privateKeyBlock.Type)
+
+	switch privateKeyBlock.Type {
+	case "RSA PRIVATE KEY":
+		var privateKey *rsa.PrivateKey
+		privateKey, err = x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
+		if err != nil {
+			return nil, errors.Errorf("cannot parse RSA key in private key file '%s'", privKeyPath)
+		}
+
+		return privateKey, nil
+	case "PRIVATE KEY":
+		var key interface{}
+		key, err = x509.ParsePKCS8PrivateKey(privateKeyBlock.Bytes)
+		if err != nil {
+			return nil, errors.Errorf("cannot parse PKCS#8 key in private key file '%s'", privKeyPath)
+		}
+
+		switch privateKey := key.(type) {
+		case *rsa.PrivateKey:
+			return privateKey, nil
+		case ed25519.PrivateKey:
+			return privateKey, nil
+		default:
+			return nil, errors.Errorf("unsupported key type %T in private key file '%s'", key, privKeyPath)
+		}
+	default:
+		return nil, errors.Errorf("expected private key type to be 'RSA PRIVATE KEY' or 'PRIVATE KEY' but was '%s'", privateKeyBlock.Type)
 	}
+}
 
-	var privateKey *rsa.PrivateKey
-	privateKey, err = x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
+// loadPublicKey reads a PKCS#1 RSA key or a PKIX RSA or Ed25519 key.
+func loadPublicKey(pubKeyPath string) (crypto.PublicKey, error) {
+	publicKeyBlock, err := readPublicKeyBlock(pubKeyPath)
 	if err != nil {
-		return nil, errors.Errorf("cannot parse RSA key in private key file '%s'", privKeyPath)
+		return nil, err
 	}
 
-	return privateKey, nil
+	var publicKey crypto.PublicKey
+	publicKey, err = parsePublicKeyBlock(publicKeyBlock)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse public key file '%s'", pubKeyPath)
+	}
+
+	return publicKey, nil
 }
 
-func loadPublicKey(pubKeyPath string) (*rsa.PublicKey, error) {
+func readPublicKeyBlock(pubKeyPath string) (*pem.Block, error) {
 	publicKeyPem, err := os.ReadFile(pubKeyPath)
 	if err != nil {
 		return nil, errors.Wrapf(err, "cannot open public key file '%s'", pubKeyPath)
 	}
 
 	publicKeyBlock, _ := pem.Decode(publicKeyPem)
-	if publicKeyBlock == nil || publicKeyBlock.Type != "RSA PUBLIC KEY" {
+	if publicKeyBlock == nil {
 		return nil, errors.Errorf("cannot decode public key file '%s'", pubKeyPath)
 	}
-	if publicKeyBlock.Type != "RSA PUBLIC KEY" {
-		return nil, errors.Errorf("expected public key type to be 'RSA PUBLIC KEY' but was '%s'", publicKeyBlock.Type)
+
+	return publicKeyBlock, nil
+}
+
+func parsePublicKeyBlock(publicKeyBlock *pem.Block) (crypto.PublicKey, error) {
+	switch publicKeyBlock.Type {
+	case "RSA PUBLIC KEY":
+		publicKey, err := x509.ParsePKCS1PublicKey(publicKeyBlock.Bytes)
+		if err != nil {
+			return nil, errors.New("cannot parse RSA key")
+		}
+
+		return publicKey, nil
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(publicKeyBlock.Bytes)
+		if err != nil {
+			return nil, errors.New("cannot parse PKIX key")
+		}
+
+		switch publicKey := key.(type) {
+		case *rsa.PublicKey:
+			return publicKey, nil
+		case ed25519.PublicKey:
+			return publicKey, nil
+		default:
+			return nil, errors.Errorf("unsupported key type %T", key)
+		}
+	default:
+		return nil, errors.Errorf("expected public key type to be 'RSA PUBLIC KEY' or 'PUBLIC KEY' but was '%s'", publicKeyBlock.Type)
+	}
+}
+
+// encodePublicKeyBlock is the inverse of parsePublicKeyBlock. RSA keys keep using
+// PKCS#1 so that their files look the same as before Ed25519 was supported.
+func encodePublicKeyBlock(publicKey crypto.PublicKey) (*pem.Block, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return &pem.Block{
+			Type:  "RSA PUBLIC KEY",
+			Bytes: x509.MarshalPKCS1PublicKey(key),
+		}, nil
+	case ed25519.PublicKey:
+		b, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot marshal Ed25519 public key")
+		}
+
+		return &pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: b,
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported key type %T", publicKey)
+	}
+}
+
+func encodePrivateKeyBlock(privateKey crypto.Signer) (*pem.Block, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}, nil
+	case ed25519.PrivateKey:
+		b, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot marshal Ed25519 private key")
+		}
+
+		return &pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: b,
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported key type %T", privateKey)
+	}
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// keyFingerprint returns the ID of a public key: the SHA-256 hash of its PKIX
+// encoding, formatted like OpenSSH fingerprints.
+func keyFingerprint(publicKey crypto.PublicKey) (string, error) {
+	b, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot marshal public key")
+	}
+
+	hash := sha256.Sum256(b)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(hash[:]), nil
+}
+
+func printFingerprint() error {
+	selfKey, err := loadSelfKey()
+	if err != nil {
+		return errors.Wrap(err, "cannot load self key")
 	}
 
-	var publicKey *rsa.PublicKey
-	publicKey, err = x509.ParsePKCS1PublicKey(publicKeyBlock.Bytes)
+	var keyID string
+	keyID, err = keyFingerprint(selfKey.Public())
 	if err != nil {
-		return nil, errors.Errorf("cannot parse RSA key in public key file '%s'", pubKeyPath)
+		return errors.Wrap(err, "cannot determine self key ID")
 	}
 
-	return publicKey, nil
+	fmt.Println(keyID)
+	return nil
+}
+
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case keyTypeEd25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot generate Ed25519 key")
+		}
+
+		return privateKey, nil
+	case keyTypeRSA:
+		privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot generate RSA key")
+		}
+
+		return privateKey, nil
+	default:
+		return nil, errors.Errorf("unknown key type '%s'", keyType)
+	}
 }
 
-func writeNewSelfKey() (err error) {
+// keyTypeOf returns the key type that generateKey would use to create key.
+func keyTypeOf(key crypto.Signer) string {
+	switch key.Public().(type) {
+	case ed25519.PublicKey:
+		return keyTypeEd25519
+	case *rsa.PublicKey:
+		return keyTypeRSA
+	default:
+		return ""
+	}
+}
+
+func writeNewSelfKey(keyType string) (err error) {
 	privKeyPath := selfKeyDirName + string(os.PathSeparator) + selfPrivKeyName
 	if _, err := os.Stat(privKeyPath); err == nil {
 		return errors.Errorf("file '%s' already exists", privKeyPath)
@@ -87,57 +265,65 @@ func writeNewSelfKey() (err error) {
 		return errors.Errorf("file '%s' already exists", pubKeyPath)
 	}
 
-	var privateKey *rsa.PrivateKey
-	privateKey, err = rsa.GenerateKey(rand.Reader, 4096)
+	var privateKey crypto.Signer
+	privateKey, err = generateKey(keyType)
 	if err != nil {
 		return errors.Wrap(err, "cannot generate key")
 	}
-	publicKey := &privateKey.PublicKey
 
-	privateKeyBytes := x509.MarshalPKCS1PrivateKey(privateKey)
-	privateKeyBlock := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: privateKeyBytes,
+	var privateKeyBlock *pem.Block
+	privateKeyBlock, err = encodePrivateKeyBlock(privateKey)
+	if err != nil {
+		return errors.Wrap(err, "cannot encode private key")
 	}
 
-	var privateKeyFile *os.File
-	privateKeyFile, err = os.OpenFile(privKeyPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
-	if err != nil {
-		return errors.Wrapf(err, "cannot create file '%s'", privKeyPath)
+	if err = writePEMFile(privKeyPath, privateKeyBlock); err != nil {
+		return errors.Wrap(err, "cannot write private key")
 	}
-	defer func() {
-		err = firstError(err, errors.Wrapf(privateKeyFile.Close(), "cannot close file '%s'", privKeyPath))
-	}()
 
-	if err = pem.Encode(privateKeyFile, privateKeyBlock); err != nil {
-		return errors.Wrap(err, "cannot encode private key to PEM")
+	var publicKeyBlock *pem.Block
+	publicKeyBlock, err = encodePublicKeyBlock(privateKey.Public())
+	if err != nil {
+		return errors.Wrap(err, "cannot encode public key")
 	}
 
-	publicKeyBytes := x509.MarshalPKCS1PublicKey(publicKey)
-	publicKeyBlock := &pem.Block{
-		Type:  "RSA PUBLIC KEY",
-		Bytes: publicKeyBytes,
+	if err = writePEMFile(pubKeyPath, publicKeyBlock); err != nil {
+		return errors.Wrap(err, "cannot write public key")
 	}
 
-	var publicKeyFile *os.File
-	publicKeyFile, err = os.OpenFile(pubKeyPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	return
+}
+
+func writePEMFile(path string, block *pem.Block) (err error) {
+	var f *os.File
+	f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
-		return errors.Wrapf(err, "cannot create file '%s'", pubKeyPath)
+		return errors.Wrapf(err, "cannot create file '%s'", path)
 	}
 	defer func() {
-		err = firstError(err, errors.Wrapf(publicKeyFile.Close(), "cannot close file '%s'", pubKeyPath))
+		err = firstError(err, errors.Wrapf(f.Close(), "cannot close file '%s'", path))
 	}()
 
-	if err = pem.Encode(publicKeyFile, publicKeyBlock); err != nil {
-		return errors.Wrap(err, "cannot encode public key to PEM")
+	if err = pem.Encode(f, block); err != nil {
+		return errors.Wrap(err, "cannot encode key to PEM")
 	}
 
 	return
 }
 
-func signMessage(message []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
-	hash := sha256.Sum256(message)
-	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hash[:])
+func signMessage(message []byte, privateKey crypto.Signer) ([]byte, error) {
+	var signature []byte
+	var err error
+	switch privateKey.Public().(type) {
+	case ed25519.PublicKey:
+		// Ed25519 hashes the message itself.
+		signature, err = privateKey.Sign(rand.Reader, message, crypto.Hash(0))
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(message)
+		signature, err = privateKey.Sign(rand.Reader, hash[:], crypto.SHA256)
+	default:
+		return nil, errors.Errorf("unsupported key type %T", privateKey.Public())
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot sign message")
 	}
@@ -145,7 +331,18 @@ func signMessage(message []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
 	return signature, nil
 }
 
-func verifyMessage(message []byte, signature []byte, publicKey *rsa.PublicKey) error {
-	hash := sha256.Sum256(message)
-	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature)
+func verifyMessage(message []byte, signature []byte, publicKey crypto.PublicKey) error {
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, message, signature) {
+			return errors.New("invalid Ed25519 signature")
+		}
+
+		return nil
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	default:
+		return errors.Errorf("unsupported key type %T", publicKey)
+	}
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestKeys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+
+	// generateKey uses 4096 bit RSA keys, which is needlessly slow for tests.
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]crypto.Signer{
+		keyTypeEd25519: newTestKey(t),
+		keyTypeRSA:     rsaKey,
+	}
+}
+
+func TestSignVerifyMessage(t *testing.T) {
+	keys := newTestKeys(t)
+	message := []byte("message")
+
+	for keyType, key := range keys {
+		signature, err := signMessage(message, key)
+		if err != nil {
+			t.Fatalf("%s: %v", keyType, err)
+		}
+
+		if err = verifyMessage(message, signature, key.Public()); err != nil {
+			t.Errorf("%s: valid signature: %v", keyType, err)
+		}
+		if err = verifyMessage([]byte("other message"), signature, key.Public()); err == nil {
+			t.Errorf("%s: other message verified", keyType)
+		}
+
+		for otherType, other := range keys {
+			if otherType != keyType && verifyMessage(message, signature, other.Public()) == nil {
+				t.Errorf("%s: verified with %s key", keyType, otherType)
+			}
+		}
+		if err = verifyMessage(message, signature, newTestKey(t).Public()); err == nil {
+			t.Errorf("%s: verified with another Ed25519 key", keyType)
+		}
+	}
+}
+
+func TestKeyFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	for keyType, key := range newTestKeys(t) {
+		privateBlock, err := encodePrivateKeyBlock(key)
+		if err != nil {
+			t.Fatalf("%s: %v", keyType, err)
+		}
+		publicBlock, err := encodePublicKeyBlock(key.Public())
+		if err != nil {
+			t.Fatalf("%s: %v", keyType, err)
+		}
+
+		privKeyPath := filepath.Join(dir, keyType+".pem")
+		pubKeyPath := filepath.Join(dir, keyType+".pub")
+		if err = writePEMFile(privKeyPath, privateBlock); err != nil {
+			t.Fatal(err)
+		}
+		if err = writePEMFile(pubKeyPath, publicBlock); err != nil {
+			t.Fatal(err)
+		}
+
+		privateKey, err := loadPrivateKey(privKeyPath)
+		if err != nil {
+			t.Fatalf("%s: %v", keyType, err)
+		}
+		if !publicKeysEqual(privateKey.Public(), key.Public()) {
+			t.Errorf("%s: loaded private key differs", keyType)
+		}
+
+		publicKey, err := loadPublicKey(pubKeyPath)
+		if err != nil {
+			t.Fatalf("%s: %v", keyType, err)
+		}
+		if !publicKeysEqual(publicKey, key.Public()) {
+			t.Errorf("%s: loaded public key differs", keyType)
+		}
+	}
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	keys := newTestKeys(t)
+	seen := make(map[string]string)
+
+	for keyType, key := range keys {
+		keyID := testKeyID(t, key.Public())
+		if !strings.HasPrefix(keyID, "SHA256:") {
+			t.Errorf("%s: got key ID %q, want SHA256: prefix", keyType, keyID)
+		}
+		if again := testKeyID(t, key.Public()); again != keyID {
+			t.Errorf("%s: key ID changed from %q to %q", keyType, keyID, again)
+		}
+		if other, ok := seen[keyID]; ok {
+			t.Errorf("%s: same key ID as %s key", keyType, other)
+		}
+		seen[keyID] = keyType
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	key, err := generateKey(keyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signMessage([]byte("message"), key); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = generateKey("dsa"); err == nil {
+		t.Error("unknown key type: expected error")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// shutdownLevels orders remotes for a fleet shutdown. A remote is in a later
+// level than every remote that depends on it, so that, for example, hosts go
+// down before the NAS they mount. The levels are determined on the dependency
+// graph of all configured remotes, so that a remote still goes down before the
+// remotes it depends on through remotes that are not in the list. Remotes keep
+// their config order within a level, and empty levels are left out.
+func shutdownLevels(all []Remote, remotes []Remote) ([][]Remote, error) {
+	levelOf, err := dependencyLevels(all)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]Remote, len(remotes))
+	for _, remote := range remotes {
+		if _, ok := levelOf[remote.Host]; !ok {
+			return nil, errors.Errorf("unknown remote '%s'", remote.Host)
+		}
+		selected[remote.Host] = remote
+	}
+
+	var byLevel [][]Remote
+	for _, remote := range all {
+		r, ok := selected[remote.Host]
+		if !ok {
+			continue
+		}
+
+		level := levelOf[remote.Host]
+		for len(byLevel) <= level {
+			byLevel = append(byLevel, nil)
+		}
+		byLevel[level] = append(byLevel[level], r)
+	}
+
+	levels := make([][]Remote, 0, len(byLevel))
+	for _, level := range byLevel {
+		if len(level) > 0 {
+			levels = append(levels, level)
+		}
+	}
+
+	return levels, nil
+}
+
+// dependencyLevels returns the shutdown level of every remote: 0 for remotes
+// nothing depends on, and otherwise one more than the highest level of the
+// remotes that depend on it. Dependencies on unknown remotes are ignored.
+func dependencyLevels(remotes []Remote) (map[string]int, error) {
+	known := make(map[string]bool, len(remotes))
+	for _, remote := range remotes {
+		known[remote.Host] = true
+	}
+
+	// Number of remotes that still have to be assigned a level before each remote.
+	dependents := make(map[string]int, len(remotes))
+	for _, remote := range remotes {
+		for _, host := range remote.DependsOn {
+			if known[host] {
+				dependents[host]++
+			}
+		}
+	}
+
+	levelOf := make(map[string]int, len(remotes))
+	for level := 0; len(levelOf) < len(remotes); level++ {
+		var current []Remote
+		for _, remote := range remotes {
+			if _, done := levelOf[remote.Host]; !done && dependents[remote.Host] == 0 {
+				current = append(current, remote)
+			}
+		}
+
+		if len(current) == 0 {
+			var cycle []string
+			for _, remote := range remotes {
+				if _, done := levelOf[remote.Host]; !done {
+					cycle = append(cycle, remote.Host)
+				}
+			}
+			return nil, errors.Errorf("dependency cycle among remotes %s", strings.Join(cycle, ", "))
+		}
+
+		for _, remote := range current {
+			levelOf[remote.Host] = level
+			for _, host := range remote.DependsOn {
+				if known[host] {
+					dependents[host]--
+				}
+			}
+		}
+	}
+
+	return levelOf, nil
+}
+
+func validateDependencies(remotes []Remote) error {
+	hosts := make(map[string]bool, len(remotes))
+	for _, remote := range remotes {
+		if hosts[remote.Host] {
+			return errors.Errorf("remote '%s' is configured twice", remote.Host)
+		}
+		hosts[remote.Host] = true
+	}
+
+	for _, remote := range remotes {
+		for _, host := range remote.DependsOn {
+			if !hosts[host] {
+				return errors.Errorf("remote '%s' depends on unknown remote '%s'", remote.Host, host)
+			}
+		}
+	}
+
+	_, err := dependencyLevels(remotes)
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// levelHosts formats levels like "a b | c".
+func levelHosts(levels [][]Remote) string {
+	var parts []string
+	for _, level := range levels {
+		var hosts []string
+		for _, remote := range level {
+			hosts = append(hosts, remote.Host)
+		}
+		parts = append(parts, strings.Join(hosts, " "))
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+func TestShutdownLevels(t *testing.T) {
+	// web and backup mount the nas, which needs the switch. The switch also
+	// carries the printer, which nothing depends on.
+	all := []Remote{
+		{Host: "switch"},
+		{Host: "nas", DependsOn: []string{"switch"}},
+		{Host: "web", DependsOn: []string{"nas"}},
+		{Host: "printer", DependsOn: []string{"switch"}},
+		{Host: "backup", DependsOn: []string{"nas", "switch"}},
+		{Host: "laptop"},
+	}
+
+	byHost := func(hosts ...string) []Remote {
+		var remotes []Remote
+		for _, host := range hosts {
+			for _, remote := range all {
+				if remote.Host == host {
+					remotes = append(remotes, remote)
+				}
+			}
+		}
+		return remotes
+	}
+
+	tests := []struct {
+		remotes []Remote
+		want    string
+	}{
+		{all, "web printer backup laptop | nas | switch"},
+		{byHost("switch", "nas"), "nas | switch"},
+		// web still goes before the switch without the nas in between.
+		{byHost("switch", "web"), "web | switch"},
+		// Config order within a level, whatever the order of the list.
+		{byHost("laptop", "backup", "web"), "web backup laptop"},
+		{byHost("printer"), "printer"},
+		{nil, ""},
+	}
+
+	for _, test := range tests {
+		levels, err := shutdownLevels(all, test.remotes)
+		if err != nil {
+			t.Fatalf("%s: %v", levelHosts([][]Remote{test.remotes}), err)
+		}
+		if got := levelHosts(levels); got != test.want {
+			t.Errorf("%s: got %q, want %q", levelHosts([][]Remote{test.remotes}), got, test.want)
+		}
+	}
+}
+
+func TestShutdownLevelsUnknownRemote(t *testing.T) {
+	all := []Remote{{Host: "nas"}}
+	if _, err := shutdownLevels(all, []Remote{{Host: "web"}}); err == nil {
+		t.Fatal("got no error for a remote that is not configured")
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		remotes []Remote
+		wantErr string
+	}{
+		{"valid", []Remote{{Host: "a", DependsOn: []string{"b"}}, {Host: "b"}}, ""},
+		{"unknown", []Remote{{Host: "a", DependsOn: []string{"c"}}, {Host: "b"}}, "depends on unknown remote 'c'"},
+		{"duplicate", []Remote{{Host: "a"}, {Host: "b"}, {Host: "a"}}, "remote 'a' is configured twice"},
+		{"self", []Remote{{Host: "a", DependsOn: []string{"a"}}}, "dependency cycle among remotes a"},
+		{"cycle", []Remote{{Host: "a", DependsOn: []string{"b"}}, {Host: "b", DependsOn: []string{"c"}}, {Host: "c", DependsOn: []string{"a"}}, {Host: "d", DependsOn: []string{"a"}}}, "dependency cycle among remotes a, b, c"},
+	}
+
+	for _, test := range tests {
+		err := validateDependencies(test.remotes)
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%s: got error %v", test.name, err)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
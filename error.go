@@ -1,5 +1,47 @@
 package main
 
+import (
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrRemoteUnauthorized is returned when a remote does not accept the
+	// signature of a request.
+	ErrRemoteUnauthorized = errors.New("remote rejected request as unauthorized")
+
+	// ErrRemoteForbidden is returned when the self key is not allowed to perform
+	// an action on a remote.
+	ErrRemoteForbidden = errors.New("remote rejected request as out of scope")
+
+	// ErrRemoteReplayRejected is returned when a remote has already seen the
+	// request ID of a request.
+	ErrRemoteReplayRejected = errors.New("remote rejected request as a replay")
+
+	// ErrRemoteActionCancelled is returned when a delayed power action was
+	// cancelled on the remote before it ran.
+	ErrRemoteActionCancelled = errors.New("power action was cancelled on remote")
+
+	// ErrRemoteFailed is returned when a remote answered that it failed to
+	// carry out an action, like a power action whose command was killed.
+	ErrRemoteFailed = errors.New("remote failed to carry out the action")
+
+	// ErrLocked is returned when a power action was refused because the node
+	// holds maintenance locks.
+	ErrLocked = errors.New("node is locked for maintenance")
+
+	// ErrHookAborted is returned when a failing pre-power hook aborted a power
+	// action.
+	ErrHookAborted = errors.New("power action was aborted by a pre-power hook")
+
+	// ErrRemoteNoResponse is returned when a remote did not answer a power
+	// request, which a remote that goes down before its response is sent cannot.
+	ErrRemoteNoResponse = errors.New("remote did not answer the power request")
+
+	// ErrUntrustedResponse is returned when a response is not signed by the
+	// pinned key of the remote.
+	ErrUntrustedResponse = errors.New("remote response is not signed by its pinned key")
+)
+
 func firstError(errs ...error) error {
 	for _, err := range errs {
 		if err != nil {
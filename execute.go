@@ -1,15 +1,66 @@
 package main
 
 import (
-	"os/exec"
-	"time"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sleepActionSuspend     = "suspend"
+	sleepActionHibernate   = "hibernate"
+	sleepActionHybridSleep = "hybrid-sleep"
 )
 
-func (p *program) ExecutePoweroff(poweroffDelayMsec int) error {
-	if poweroffDelayMsec > 0 {
-		time.Sleep(time.Duration(poweroffDelayMsec) * time.Millisecond)
+// sysPowerStatePath lists the sleep states the kernel supports.
+var sysPowerStatePath = "/sys/power/state"
+
+// sleepActions are the sleep actions nodes support, in the order they are reported.
+var sleepActions = []string{sleepActionSuspend, sleepActionHibernate, sleepActionHybridSleep}
+
+func isSleepAction(action string) bool {
+	return containsString(sleepActions, action)
+}
+
+// supportedSleepActions returns the sleep actions the kernel supports according
+// to /sys/power/state. Suspend needs "mem" or "freeze", hibernate needs "disk"
+// and hybrid sleep needs both.
+func supportedSleepActions() ([]string, error) {
+	b, err := os.ReadFile(sysPowerStatePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read '%s'", sysPowerStatePath)
 	}
 
-	cmd := exec.Command("poweroff")
-	return cmd.Run()
+	states := make(map[string]bool)
+	for _, state := range strings.Fields(string(b)) {
+		states[state] = true
+	}
+
+	canSuspend := states["mem"] || states["freeze"]
+	supported := make([]string, 0, len(sleepActions))
+	if canSuspend {
+		supported = append(supported, sleepActionSuspend)
+	}
+	if states["disk"] {
+		supported = append(supported, sleepActionHibernate)
+	}
+	if canSuspend && states["disk"] {
+		supported = append(supported, sleepActionHybridSleep)
+	}
+
+	return supported, nil
+}
+
+func (p *program) ExecutePoweroff() error {
+	return p.Executor.Poweroff()
+}
+
+func (p *program) ExecuteReboot() error {
+	return p.Executor.Reboot()
+}
+
+// ExecuteSleep puts the node in one of the sleepActions.
+func (p *program) ExecuteSleep(action string) error {
+	return p.Executor.Sleep(action)
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// setSysPowerState makes supportedSleepActions read states for the rest of the
+// test.
+func setSysPowerState(t *testing.T, states string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "state")
+	if err := os.WriteFile(path, []byte(states), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	old := sysPowerStatePath
+	sysPowerStatePath = path
+	t.Cleanup(func() {
+		sysPowerStatePath = old
+	})
+}
+
+func TestSupportedSleepActions(t *testing.T) {
+	tests := []struct {
+		states string
+		want   []string
+	}{
+		{"", []string{}},
+		{"freeze mem\n", []string{sleepActionSuspend}},
+		{"disk\n", []string{sleepActionHibernate}},
+		{"freeze mem disk\n", []string{sleepActionSuspend, sleepActionHibernate, sleepActionHybridSleep}},
+		{"freeze disk\n", []string{sleepActionSuspend, sleepActionHibernate, sleepActionHybridSleep}},
+	}
+
+	for _, test := range tests {
+		setSysPowerState(t, test.states)
+
+		got, err := supportedSleepActions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("states %q: got %v, want %v", test.states, got, test.want)
+		}
+	}
+}
+
+func TestNodeExecuteSleepHandlerUnsupported(t *testing.T) {
+	setSysPowerState(t, "freeze mem\n")
+	p := newTestNode(t)
+
+	endpoint := "/node/execute/" + sleepActionHibernate
+	action := &nodeSleepAction{Action: sleepActionHibernate}
+	if rec := serveNodeRequest(t, p, p.nodeExecuteSleepHandler(sleepActionHibernate), endpoint, action); rec.Code != http.StatusNotImplemented {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+
+	// The sleep action is part of the signed request.
+	action = &nodeSleepAction{Action: sleepActionSuspend}
+	if rec := serveNodeRequest(t, p, p.nodeExecuteSleepHandler(sleepActionHibernate), endpoint, action); rec.Code != http.StatusUnauthorized {
+		t.Errorf("other sleep action: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
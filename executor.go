@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/kardianos/service"
+	"github.com/pkg/errors"
+)
+
+const (
+	powerExecutorPoweroff  = "poweroff"
+	powerExecutorSystemctl = "systemctl"
+	powerExecutorShutdown  = "shutdown"
+	powerExecutorLogind    = "logind"
+	powerExecutorDryRun    = "dry-run"
+)
+
+// powerExecutor carries out power actions on this node.
+type powerExecutor interface {
+	Poweroff() error
+	Reboot() error
+
+	// Sleep puts the node in one of the sleepActions.
+	Sleep(action string) error
+}
+
+// newPowerExecutor returns the executor named by Node.PowerExecutor, or the
+// dry-run executor if dryRun is set.
+func newPowerExecutor(c Config, dryRun bool, logger service.Logger) (powerExecutor, error) {
+	name := c.Node.PowerExecutor
+	if dryRun {
+		name = powerExecutorDryRun
+	}
+
+	switch name {
+	case "", powerExecutorPoweroff:
+		return commandExecutor{}, nil
+	case powerExecutorSystemctl:
+		return systemctlExecutor{}, nil
+	case powerExecutorShutdown:
+		return shutdownExecutor{DelayMin: c.Node.ShutdownDelayMin, Message: c.Node.ShutdownMessage}, nil
+	case powerExecutorLogind:
+		return logindExecutor{}, nil
+	case powerExecutorDryRun:
+		return &dryRunExecutor{logger: logger}, nil
+	default:
+		return nil, errors.Errorf("unknown PowerExecutor '%s'", name)
+	}
+}
+
+func runPowerCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	return cmd.Run()
+}
+
+// commandExecutor runs the poweroff and reboot commands.
+type commandExecutor struct{}
+
+func (commandExecutor) Poweroff() error {
+	return runPowerCommand("poweroff")
+}
+
+func (commandExecutor) Reboot() error {
+	return runPowerCommand("reboot")
+}
+
+func (commandExecutor) Sleep(action string) error {
+	return runPowerCommand("systemctl", action)
+}
+
+type systemctlExecutor struct{}
+
+func (systemctlExecutor) Poweroff() error {
+	return runPowerCommand("systemctl", "poweroff")
+}
+
+func (systemctlExecutor) Reboot() error {
+	return runPowerCommand("systemctl", "reboot")
+}
+
+func (systemctlExecutor) Sleep(action string) error {
+	return runPowerCommand("systemctl", action)
+}
+
+// shutdownExecutor runs shutdown, which warns logged in users with Message and
+// waits DelayMin minutes itself. That wait cannot be cancelled through the node
+// API. shutdown cannot put the node to sleep, so sleep actions use systemctl.
+type shutdownExecutor struct {
+	DelayMin int
+	Message  string
+}
+
+func (e shutdownExecutor) args(mode string) []string {
+	args := []string{mode, "+" + strconv.Itoa(e.DelayMin)}
+	if e.Message != "" {
+		args = append(args, e.Message)
+	}
+
+	return args
+}
+
+func (e shutdownExecutor) Poweroff() error {
+	return runPowerCommand("shutdown", e.args("-h")...)
+}
+
+func (e shutdownExecutor) Reboot() error {
+	return runPowerCommand("shutdown", e.args("-r")...)
+}
+
+func (shutdownExecutor) Sleep(action string) error {
+	return runPowerCommand("systemctl", action)
+}
+
+// logindExecutor asks systemd-logind over the system D-Bus, which needs no
+// external commands and honours logind inhibitor locks.
+type logindExecutor struct{}
+
+var logindSleepMethods = map[string]string{
+	sleepActionSuspend:     "Suspend",
+	sleepActionHibernate:   "Hibernate",
+	sleepActionHybridSleep: "HybridSleep",
+}
+
+func (e logindExecutor) call(method string) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return errors.Wrap(err, "cannot connect to system D-Bus")
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// The argument disables interactive authorization.
+	obj := conn.Object("org.freedesktop.login1", "/org/freedesktop/login1")
+	if err = obj.Call("org.freedesktop.login1.Manager."+method, 0, false).Err; err != nil {
+		return errors.Wrapf(err, "cannot call logind %s", method)
+	}
+
+	return nil
+}
+
+func (e logindExecutor) Poweroff() error {
+	return e.call("PowerOff")
+}
+
+func (e logindExecutor) Reboot() error {
+	return e.call("Reboot")
+}
+
+func (e logindExecutor) Sleep(action string) error {
+	method, ok := logindSleepMethods[action]
+	if !ok {
+		return errors.Errorf("unknown sleep action '%s'", action)
+	}
+
+	return e.call(method)
+}
+
+// recordedPowerAction is a power action the dry-run executor did not execute.
+type recordedPowerAction struct {
+	Action string
+	Time   time.Time
+}
+
+// dryRunExecutor only logs and records power actions, so nodes can be tried
+// out without shutting them down.
+type dryRunExecutor struct {
+	logger service.Logger
+
+	mu      sync.Mutex
+	actions []recordedPowerAction
+}
+
+func (e *dryRunExecutor) record(action string) error {
+	e.mu.Lock()
+	e.actions = append(e.actions, recordedPowerAction{Action: action, Time: time.Now()})
+	e.mu.Unlock()
+
+	_ = e.logger.Infof("dry run: not executing %s", action)
+	return nil
+}
+
+// Actions returns the recorded power actions, oldest first.
+func (e *dryRunExecutor) Actions() []recordedPowerAction {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]recordedPowerAction(nil), e.actions...)
+}
+
+func (e *dryRunExecutor) Poweroff() error {
+	return e.record("poweroff")
+}
+
+func (e *dryRunExecutor) Reboot() error {
+	return e.record("reboot")
+}
+
+func (e *dryRunExecutor) Sleep(action string) error {
+	return e.record(action)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func recordedActions(e *dryRunExecutor) []string {
+	var actions []string
+	for _, action := range e.Actions() {
+		actions = append(actions, action.Action)
+	}
+
+	return actions
+}
+
+func TestNewPowerExecutor(t *testing.T) {
+	tests := []struct {
+		name   string
+		dryRun bool
+		want   string
+	}{
+		{"", false, "main.commandExecutor"},
+		{powerExecutorPoweroff, false, "main.commandExecutor"},
+		{powerExecutorSystemctl, false, "main.systemctlExecutor"},
+		{powerExecutorShutdown, false, "main.shutdownExecutor"},
+		{powerExecutorLogind, false, "main.logindExecutor"},
+		{powerExecutorDryRun, false, "*main.dryRunExecutor"},
+		{powerExecutorSystemctl, true, "*main.dryRunExecutor"},
+		{"halt", false, ""},
+	}
+
+	for _, test := range tests {
+		var c Config
+		c.Node.PowerExecutor = test.name
+
+		executor, err := newPowerExecutor(c, test.dryRun, testLogger{t})
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q: got %T, want an error", test.name, executor)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.name, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", executor); got != test.want {
+			t.Errorf("%q, dry run %v: got %s, want %s", test.name, test.dryRun, got, test.want)
+		}
+	}
+}
+
+func TestShutdownExecutorArgs(t *testing.T) {
+	tests := []struct {
+		executor shutdownExecutor
+		want     string
+	}{
+		{shutdownExecutor{}, "[-h +0]"},
+		{shutdownExecutor{DelayMin: 5, Message: "maintenance"}, "[-h +5 maintenance]"},
+	}
+
+	for _, test := range tests {
+		if got := fmt.Sprint(test.executor.args("-h")); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
+
+func TestNodePowerHandlersDryRun(t *testing.T) {
+	setSysPowerState(t, "freeze mem disk\n")
+
+	p, executor := newDryRunNode(t)
+	tests := []struct {
+		endpoint string
+		handler  http.HandlerFunc
+		action   actionInterface
+	}{
+		{"/node/execute/poweroff", p.nodeExecutePoweroffHandler, &nodePoweroffAction{}},
+		{"/node/execute/reboot", p.nodeExecuteRebootHandler, &nodeRebootAction{}},
+		{"/node/execute/suspend", p.nodeExecuteSleepHandler(sleepActionSuspend), &nodeSleepAction{Action: sleepActionSuspend}},
+		{"/node/execute/hibernate", p.nodeExecuteSleepHandler(sleepActionHibernate), &nodeSleepAction{Action: sleepActionHibernate}},
+		{"/node/execute/hybrid-sleep", p.nodeExecuteSleepHandler(sleepActionHybridSleep), &nodeSleepAction{Action: sleepActionHybridSleep}},
+	}
+
+	var want []string
+	for _, test := range tests {
+		rec := serveNodeRequest(t, p, test.handler, test.endpoint, test.action)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d (%s), want %d", test.endpoint, rec.Code, rec.Body.String(), http.StatusOK)
+		}
+
+		want = append(want, test.action.ActionType())
+		if got := recordedActions(executor); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: got actions %v, want %v", test.endpoint, got, want)
+		}
+	}
+}
+
+func TestNodePowerHandlersRejectUnsigned(t *testing.T) {
+	p, executor := newDryRunNode(t)
+	req := httptest.NewRequest(http.MethodPost, "https://127.0.0.1:"+HTTPPort+"/node/execute/poweroff", nil)
+	if rec := serveRequest(p.nodeExecutePoweroffHandler, req); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if got := recordedActions(executor); len(got) != 0 {
+		t.Fatalf("got actions %v, want none", got)
+	}
+}
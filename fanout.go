@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxConcurrency  = 8
+	defaultFleetTimeout    = 2 * time.Minute
+	defaultShutdownTimeout = 10 * time.Minute
+)
+
+var (
+	errNotAttempted = errors.New("not attempted because a remote that depends on it failed")
+	errAborted      = errors.New("not attempted because an earlier remote failed")
+)
+
+// fleetResult is the outcome of a fleet operation on one remote.
+type fleetResult struct {
+	Host  string
+	Hooks []hookResult
+	Err   error
+
+	// Set when the remote was powered down.
+	Verdict offlineVerdict
+}
+
+// Outcome describes the result in a few words, followed by the error if any.
+func (r fleetResult) Outcome() string {
+	switch {
+	case r.Err == errNotAttempted, r.Err == errAborted, r.Err == errKeptUp:
+		return r.Err.Error()
+	case r.Err == errStillUp:
+		return string(verdictStillUp)
+	case r.Err != nil && r.Verdict != "":
+		return fmt.Sprintf("failed (%s): %v", r.Verdict, r.Err)
+	case r.Err != nil:
+		return fmt.Sprintf("failed: %v", r.Err)
+	case r.Verdict != "":
+		return string(r.Verdict)
+	default:
+		return "OK"
+	}
+}
+
+// remotePowerFunc runs a power action on one remote.
+type remotePowerFunc func(ctx context.Context, r Remote) ([]hookResult, error)
+
+// fleetContext returns a context that ends after WebAdmin.FleetTimeoutSec, the
+// deadline for an operation on all remotes.
+func (p *program) fleetContext(parent context.Context) (context.Context, context.CancelFunc) {
+	timeout := defaultFleetTimeout
+	if p.Config.WebAdmin.FleetTimeoutSec > 0 {
+		timeout = time.Duration(p.Config.WebAdmin.FleetTimeoutSec) * time.Second
+	}
+
+	return context.WithTimeout(parent, timeout)
+}
+
+// shutdownContext returns a context that ends after WebAdmin.ShutdownTimeoutSec,
+// the deadline for powering down remotes level by level.
+func (p *program) shutdownContext(parent context.Context) (context.Context, context.CancelFunc) {
+	timeout := defaultShutdownTimeout
+	if p.Config.WebAdmin.ShutdownTimeoutSec > 0 {
+		timeout = time.Duration(p.Config.WebAdmin.ShutdownTimeoutSec) * time.Second
+	}
+
+	return context.WithTimeout(parent, timeout)
+}
+
+// detachedFleetContext returns a fleet context for a webadmin request that ends
+// when the service stops rather than when the client disconnects, so that a
+// closed browser tab does not leave a fleet operation half done.
+func (p *program) detachedFleetContext() (context.Context, context.CancelFunc) {
+	return p.fleetContext(p.t.Context(nil))
+}
+
+// detachedShutdownContext is like detachedFleetContext with the shutdown
+// deadline.
+func (p *program) detachedShutdownContext() (context.Context, context.CancelFunc) {
+	return p.shutdownContext(p.t.Context(nil))
+}
+
+// fanOut runs fn for every remote, at most WebAdmin.MaxConcurrency at a time, and
+// returns the errors in the order of remotes. Remotes that have not started when
+// ctx ends get the error of ctx.
+func (p *program) fanOut(ctx context.Context, remotes []Remote, fn func(ctx context.Context, i int, r Remote) error) []error {
+	limit := p.Config.WebAdmin.MaxConcurrency
+	if limit <= 0 {
+		limit = defaultMaxConcurrency
+	}
+
+	errs := make([]error, len(remotes))
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, remote := range remotes {
+		// When a slot frees up as ctx ends, select may pick either case.
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, remote Remote) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			errs[i] = fn(ctx, i, remote)
+		}(i, remote)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// fanOutPower runs fn on all remotes concurrently.
+func (p *program) fanOutPower(ctx context.Context, remotes []Remote, fn remotePowerFunc) []fleetResult {
+	results := make([]fleetResult, len(remotes))
+	errs := p.fanOut(ctx, remotes, func(ctx context.Context, i int, r Remote) error {
+		var err error
+		results[i].Hooks, err = fn(ctx, r)
+		return err
+	})
+
+	for i, remote := range remotes {
+		results[i].Host = remote.Host
+		results[i].Err = errs[i]
+	}
+
+	return results
+}
+
+// powerDownLevels powers down the remotes of each level concurrently with action,
+// which is "power-down", "poweroff" or a sleep action, and waits until they went
+// offline before it starts the next level. Remotes that failed or stayed up keep
+// the remotes they depend on up, directly or through other configured remotes.
+// Unless continueOnError is set, later levels are not attempted at all once a
+// remote failed.
+func (p *program) powerDownLevels(ctx context.Context, levels [][]Remote, action string, force, continueOnError bool) []fleetResult {
+	// Remotes that must stay up because a remote that depends on them is up.
+	keepUp := make(map[string]bool)
+	var keepDependenciesUp func(host string)
+	keepDependenciesUp = func(host string) {
+		remote, _ := p.remoteByHost(host)
+		for _, dependency := range remote.DependsOn {
+			if !keepUp[dependency] {
+				keepUp[dependency] = true
+				keepDependenciesUp(dependency)
+			}
+		}
+	}
+
+	var results []fleetResult
+	anyFailed := false
+	for _, level := range levels {
+		levelResults := make([]fleetResult, len(level))
+		var todo []Remote
+		var todoIndexes []int
+		for i, remote := range level {
+			levelResults[i].Host = remote.Host
+			switch {
+			case anyFailed && !continueOnError:
+				levelResults[i].Err = errAborted
+			case keepUp[remote.Host]:
+				levelResults[i].Err = errNotAttempted
+			default:
+				todo = append(todo, remote)
+				todoIndexes = append(todoIndexes, i)
+			}
+		}
+
+		errs := p.fanOut(ctx, todo, func(ctx context.Context, j int, r Remote) error {
+			result := &levelResults[todoIndexes[j]]
+			var err error
+			switch {
+			case action == scheduleActionPowerDown:
+				result.Hooks, err = p.PowerDownRemote(ctx, r, force)
+			case isSleepAction(action):
+				result.Hooks, err = p.SleepRemote(ctx, r, action, force)
+			default:
+				result.Hooks, err = p.PoweroffRemote(ctx, r, force)
+			}
+			// A remote that runs the action synchronously may go down before its
+			// response arrives, or report the action as failed when it is killed on
+			// the way down, so only whether it went offline tells.
+			if err != nil && !errors.Is(err, ErrRemoteNoResponse) && !errors.Is(err, ErrRemoteFailed) {
+				return err
+			}
+
+			result.Verdict = p.waitOffline(ctx, r, powerDownDelay(r, action))
+			switch result.Verdict {
+			case verdictConfirmedOff:
+				return nil
+			case verdictStillUp:
+				return firstError(err, errStillUp)
+			default:
+				// Without a response nor a confirmation the action may not have run.
+				return err
+			}
+		})
+
+		for j, i := range todoIndexes {
+			levelResults[i].Err = errs[j]
+			if errs[j] != nil {
+				anyFailed = true
+				keepDependenciesUp(level[i].Host)
+			}
+		}
+		results = append(results, levelResults...)
+	}
+
+	return results
+}
+
+// fleetError returns the first error of the results, if any.
+func fleetError(results []fleetResult) error {
+	for _, result := range results {
+		if result.Err != nil && result.Err != errNotAttempted && result.Err != errAborted {
+			return errors.Wrapf(result.Err, "remote '%s'", result.Host)
+		}
+	}
+
+	return nil
+}
+
+// writeFleetReport appends the pre-power hook results and the outcome of every
+// remote to report.
+func writeFleetReport(report *bytes.Buffer, results []fleetResult) {
+	for _, result := range results {
+		writeHookReport(report, result.Host, result.Hooks)
+		_, _ = fmt.Fprintf(report, "%s: %s\n", result.Host, result.Outcome())
+	}
+}
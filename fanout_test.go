@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func testRemotes(n int) []Remote {
+	remotes := make([]Remote, n)
+	for i := range remotes {
+		remotes[i] = Remote{Host: fmt.Sprintf("host%d", i)}
+	}
+
+	return remotes
+}
+
+func TestFanOutLimit(t *testing.T) {
+	for _, test := range []struct {
+		maxConcurrency int
+		want           int
+	}{
+		{2, 2},
+		{1, 1},
+		{0, defaultMaxConcurrency},
+	} {
+		p := &program{}
+		p.Config.WebAdmin.MaxConcurrency = test.maxConcurrency
+
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		errs := p.fanOut(context.Background(), testRemotes(3*defaultMaxConcurrency), func(ctx context.Context, i int, r Remote) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+
+			if i%2 == 1 {
+				return errors.Errorf("failed %s", r.Host)
+			}
+			return nil
+		})
+
+		if maxRunning != test.want {
+			t.Errorf("MaxConcurrency %d: got %d running at once, want %d", test.maxConcurrency, maxRunning, test.want)
+		}
+		for i, err := range errs {
+			want := "<nil>"
+			if i%2 == 1 {
+				want = fmt.Sprintf("failed host%d", i)
+			}
+			if fmt.Sprint(err) != want {
+				t.Errorf("MaxConcurrency %d: remote %d: got error %v, want %q", test.maxConcurrency, i, err, want)
+			}
+		}
+	}
+}
+
+func TestFanOutDeadline(t *testing.T) {
+	p := &program{}
+	p.Config.WebAdmin.MaxConcurrency = 2
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelFunc()
+
+	var mu sync.Mutex
+	started := make(map[int]bool)
+	start := time.Now()
+	errs := p.fanOut(ctx, testRemotes(5), func(ctx context.Context, i int, r Remote) error {
+		mu.Lock()
+		started[i] = true
+		mu.Unlock()
+
+		// The first remote answers, the others hang until the deadline.
+		if i == 0 {
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("fanOut took %s after the deadline", elapsed)
+	}
+	if errs[0] != nil {
+		t.Errorf("remote 0: got error %v, want none", errs[0])
+	}
+	for i := 1; i < len(errs); i++ {
+		if errs[i] != context.DeadlineExceeded {
+			t.Errorf("remote %d: got error %v, want %v", i, errs[i], context.DeadlineExceeded)
+		}
+	}
+
+	// Remotes 1 and 2 hold both slots until the deadline, so the rest never start.
+	if !started[1] || !started[2] || started[3] || started[4] {
+		t.Errorf("got started remotes %v, want 0, 1 and 2", started)
+	}
+}
+
+func TestFleetAndShutdownContext(t *testing.T) {
+	checkDeadline := func(name string, newContext func(context.Context) (context.Context, context.CancelFunc), want time.Duration) {
+		ctx, cancelFunc := newContext(context.Background())
+		defer cancelFunc()
+
+		deadline, ok := ctx.Deadline()
+		if left := time.Until(deadline); !ok || left > want || left < want-10*time.Second {
+			t.Errorf("%s: got deadline in %s, want %s", name, left, want)
+		}
+	}
+
+	p := &program{}
+	checkDeadline("fleet default", p.fleetContext, 2*time.Minute)
+	checkDeadline("shutdown default", p.shutdownContext, 10*time.Minute)
+
+	p.Config.WebAdmin.FleetTimeoutSec = 30
+	p.Config.WebAdmin.ShutdownTimeoutSec = 1800
+	checkDeadline("fleet", p.fleetContext, 30*time.Second)
+	checkDeadline("shutdown", p.shutdownContext, 30*time.Minute)
+}
+
+func TestDetachedFleetContext(t *testing.T) {
+	p := &program{}
+	ctx, cancelFunc := p.detachedFleetContext()
+	defer cancelFunc()
+
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("got no fleet deadline")
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("got error %v before the service stopped", ctx.Err())
+	}
+
+	p.t.Kill(nil)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context did not end when the service stopped")
+	}
+}
+
+func TestWriteFleetReport(t *testing.T) {
+	results := []fleetResult{
+		{Host: "web", Verdict: verdictConfirmedOff},
+		{Host: "backup", Verdict: verdictStillUp, Err: errStillUp},
+		{Host: "db", Verdict: verdictUnknown, Err: errors.New("remote returned error: signal: killed")},
+		{Host: "nas", Err: errNotAttempted},
+		{Host: "printer", Hooks: []hookResult{{Name: "drain", ExitCode: 1}}, Err: errors.New("locked")},
+		{Host: "switch"},
+	}
+
+	var report bytes.Buffer
+	writeFleetReport(&report, results)
+
+	want := `web: confirmed off
+backup: still up
+db: failed (unknown): remote returned error: signal: killed
+nas: not attempted because a remote that depends on it failed
+printer: hook 'drain' exited with code 1 after 0 ms
+printer: failed: locked
+switch: OK
+`
+	if report.String() != want {
+		t.Errorf("got report:\n%s\nwant:\n%s", report.String(), want)
+	}
+}
@@ -3,6 +3,7 @@ module github.com/milandamen/cloudcontrol
 go 1.17
 
 require (
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/kardianos/service v1.2.1
 	github.com/pkg/errors v0.9.1
 	gopkg.in/tomb.v2 v2.0.0-20161208151619-d5d1b5820637
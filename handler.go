@@ -2,13 +2,19 @@ package main
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"html/template"
 	"io"
 	"math"
+	"net"
 	"net/http"
-	"text/template"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -21,8 +27,19 @@ func (p *program) newMux() http.Handler {
 		mux.HandleFunc("/webadmin/", p.webadminHandler)
 	}
 
-	mux.HandleFunc("/node/execute/poweroff", p.nodeExecutePoweroffHandler)
-	mux.HandleFunc("/node/health", p.nodeHealthHandler)
+	mux.HandleFunc("/node/execute/poweroff", p.signResponses(p.nodeExecutePoweroffHandler))
+	mux.HandleFunc("/node/execute/reboot", p.signResponses(p.nodeExecuteRebootHandler))
+	mux.HandleFunc("/node/execute/cancel", p.signResponses(p.nodeExecuteCancelHandler))
+	mux.HandleFunc("/node/execute/command/", p.signResponses(p.nodeExecuteCommandHandler))
+	for _, action := range sleepActions {
+		mux.HandleFunc("/node/execute/"+action, p.signResponses(p.nodeExecuteSleepHandler(action)))
+	}
+	mux.HandleFunc("/node/keep-awake", p.signResponses(p.nodeKeepAwakeHandler))
+	mux.HandleFunc("/node/locks/acquire", p.signResponses(p.nodeLockHandler))
+	mux.HandleFunc("/node/locks/release", p.signResponses(p.nodeUnlockHandler))
+	mux.HandleFunc("/node/health", p.signResponses(p.nodeHealthHandler))
+	mux.HandleFunc("/node/keys/rotate", p.signResponses(p.nodeRotateKeyHandler))
+	mux.HandleFunc("/node/revocations", p.signResponses(p.nodeRevocationsHandler))
 
 	return mux
 }
@@ -30,6 +47,9 @@ func (p *program) newMux() http.Handler {
 //go:embed template/webadmin.html
 var webadminTemplate string
 
+//go:embed template/poweroff-all.html
+var poweroffAllTemplate string
+
 func (p *program) webadminHandler(rw http.ResponseWriter, r *http.Request) {
 	defer p.recoverPanic(r.RequestURI)
 
@@ -37,10 +57,23 @@ func (p *program) webadminHandler(rw http.ResponseWriter, r *http.Request) {
 	switch path {
 	case "/webadmin/":
 	case "/webadmin/execute/poweroff-all-and-self":
+	case "/webadmin/execute/distribute-revocations":
+	case "/webadmin/execute/reboot":
+	case "/webadmin/execute/reboot-all":
+	case "/webadmin/execute/poweron":
+	case "/webadmin/execute/poweron-all":
+	case "/webadmin/execute/boot-sequence":
+	case "/webadmin/execute/cancel":
+	case "/webadmin/execute/command":
+	case "/webadmin/execute/skip-schedule":
+	case "/webadmin/execute/keep-awake":
+	case "/webadmin/execute/lock":
+	case "/webadmin/execute/unlock":
 		// Requests may be handled.
 	default:
 		rw.WriteHeader(http.StatusNotFound)
 		_, _ = rw.Write([]byte("404 page not found"))
+		return
 	}
 
 	uriKey := r.URL.Query().Get("key")
@@ -64,20 +97,67 @@ func (p *program) webadminHandler(rw http.ResponseWriter, r *http.Request) {
 		p.webadminDashboardHandler(rw, r)
 	case "/webadmin/execute/poweroff-all-and-self":
 		p.webadminExecutePoweroffAllAndSelfHandler(rw, r)
+	case "/webadmin/execute/distribute-revocations":
+		p.webadminExecuteDistributeRevocationsHandler(rw, r)
+	case "/webadmin/execute/reboot":
+		p.webadminExecuteRebootHandler(rw, r)
+	case "/webadmin/execute/reboot-all":
+		p.webadminExecuteRebootAllHandler(rw, r)
+	case "/webadmin/execute/poweron":
+		p.webadminExecutePoweronHandler(rw, r)
+	case "/webadmin/execute/poweron-all":
+		p.webadminExecutePoweronAllHandler(rw, r)
+	case "/webadmin/execute/boot-sequence":
+		p.webadminExecuteBootSequenceHandler(rw, r)
+	case "/webadmin/execute/cancel":
+		p.webadminExecuteCancelHandler(rw, r)
+	case "/webadmin/execute/command":
+		p.webadminExecuteCommandHandler(rw, r)
+	case "/webadmin/execute/skip-schedule":
+		p.webadminExecuteSkipScheduleHandler(rw, r)
+	case "/webadmin/execute/keep-awake":
+		p.webadminExecuteKeepAwakeHandler(rw, r)
+	case "/webadmin/execute/lock":
+		p.webadminExecuteLockHandler(rw, r)
+	case "/webadmin/execute/unlock":
+		p.webadminExecuteUnlockHandler(rw, r)
 	}
 }
 
 type webadminDashboardData struct {
 	WebAdmin struct {
-		UriKey  string
-		Remotes []webadminDashboardDataRemote
+		UriKey             string
+		RevocationVersions map[string]int
+		Remotes            []webadminDashboardDataRemote
+		Schedule           []webadminDashboardDataRun
+		AnyLocked          bool
 	}
 }
 
+type webadminDashboardDataRun struct {
+	Rule    string
+	Action  string
+	Remotes string
+	Time    string
+	Skipped bool
+	IsNext  bool
+}
+
 type webadminDashboardDataRemote struct {
-	Host         string
-	PingStatus   string
-	HealthStatus string
+	Host             string
+	PingStatus       string
+	HealthStatus     string
+	RevocationStatus string
+	SleepStates      string
+	PowerAction      string
+	CanPoweron       bool
+	PendingActions   []pendingPowerAction
+	Commands         []string
+	IdleStatus       string
+	IdlePoweroff     bool
+	KeptAwake        bool
+	Locks            []maintenanceLock
+	DependsOn        string
 }
 
 func (p *program) webadminDashboardHandler(rw http.ResponseWriter, r *http.Request) {
@@ -89,23 +169,40 @@ func (p *program) webadminDashboardHandler(rw http.ResponseWriter, r *http.Reque
 
 	data := webadminDashboardData{}
 	data.WebAdmin.UriKey = p.Config.WebAdmin.UriKey
-	for _, remote := range p.Config.WebAdmin.Remotes {
-		dr := webadminDashboardDataRemote{Host: remote.Host}
-		ps, err := p.PingRemote(remote)
-		if err != nil {
-			dr.PingStatus = err.Error()
-		} else {
-			dr.PingStatus = string(ps)
-		}
+	data.WebAdmin.RevocationVersions = p.Config.revocations.Versions()
+	ctx, cancelFunc := p.fleetContext(r.Context())
+	defer cancelFunc()
 
-		var nhr nodeHealthResponse
-		nhr, err = p.FetchRemoteHealth(remote)
-		if err != nil {
-			dr.HealthStatus = err.Error()
-		} else {
-			dr.HealthStatus = nhr.Status
+	remotes := p.Config.WebAdmin.Remotes
+	data.WebAdmin.Remotes = make([]webadminDashboardDataRemote, len(remotes))
+	errs := p.fanOut(ctx, remotes, func(ctx context.Context, i int, remote Remote) error {
+		data.WebAdmin.Remotes[i] = p.dashboardRemote(ctx, remote, data.WebAdmin.RevocationVersions)
+		return nil
+	})
+	for i, remote := range remotes {
+		if errs[i] != nil {
+			data.WebAdmin.Remotes[i] = webadminDashboardDataRemote{Host: remote.Host, PingStatus: errs[i].Error(), HealthStatus: errs[i].Error()}
 		}
-		data.WebAdmin.Remotes = append(data.WebAdmin.Remotes, dr)
+		data.WebAdmin.AnyLocked = data.WebAdmin.AnyLocked || len(data.WebAdmin.Remotes[i].Locks) > 0
+	}
+
+	if selfLocks, err := activeLocks(); err != nil {
+		_ = p.Logger.Warning(errors.Wrap(err, "cannot read maintenance locks"))
+	} else if len(selfLocks) > 0 {
+		data.WebAdmin.AnyLocked = true
+	}
+
+	seen := make(map[string]bool)
+	for _, run := range p.scheduler.Upcoming() {
+		data.WebAdmin.Schedule = append(data.WebAdmin.Schedule, webadminDashboardDataRun{
+			Rule:    run.Rule,
+			Action:  run.Action,
+			Remotes: run.Remotes,
+			Time:    run.Time.Format("Mon 2006-01-02 15:04 MST"),
+			Skipped: run.Skipped,
+			IsNext:  !seen[run.Rule],
+		})
+		seen[run.Rule] = true
 	}
 
 	t, err := template.New("").Parse(webadminTemplate)
@@ -129,6 +226,45 @@ func (p *program) webadminDashboardHandler(rw http.ResponseWriter, r *http.Reque
 	_, _ = rw.Write(body.Bytes())
 }
 
+// dashboardRemote pings the remote and fetches its health for the dashboard.
+func (p *program) dashboardRemote(ctx context.Context, remote Remote, revocationVersions map[string]int) webadminDashboardDataRemote {
+	dr := webadminDashboardDataRemote{
+		Host:        remote.Host,
+		PowerAction: remote.PowerAction,
+		CanPoweron:  remote.MAC != "",
+		DependsOn:   strings.Join(remote.DependsOn, ", "),
+	}
+	if dr.PowerAction == "" {
+		dr.PowerAction = "poweroff"
+	}
+	ps, err := p.PingRemote(ctx, remote)
+	if err != nil {
+		dr.PingStatus = err.Error()
+	} else {
+		dr.PingStatus = string(ps)
+	}
+
+	var nhr nodeHealthResponse
+	nhr, err = p.FetchRemoteHealth(ctx, remote)
+	if err != nil {
+		dr.HealthStatus = err.Error()
+	} else {
+		dr.HealthStatus = nhr.Status
+		dr.RevocationStatus = revocationStatus(nhr, revocationVersions)
+		dr.SleepStates = strings.Join(nhr.SleepStates, ", ")
+		dr.PendingActions = nhr.PendingActions
+		dr.Commands = nhr.Commands
+		dr.Locks = nhr.Locks
+		if nhr.Idle != nil {
+			dr.IdlePoweroff = true
+			dr.KeptAwake = nhr.Idle.KeepAwakeUntil != ""
+			dr.IdleStatus = idleStatus(*nhr.Idle)
+		}
+	}
+
+	return dr
+}
+
 func (p *program) webadminExecutePoweroffAllAndSelfHandler(rw http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		rw.WriteHeader(http.StatusMethodNotAllowed)
@@ -136,146 +272,1151 @@ func (p *program) webadminExecutePoweroffAllAndSelfHandler(rw http.ResponseWrite
 		return
 	}
 
-	for _, remote := range p.Config.WebAdmin.Remotes {
-		if err := p.PoweroffRemote(remote); err != nil {
-			_ = p.Logger.Error(errors.Wrapf(err, "cannot power off remote '%s'", remote.Host))
-			rw.WriteHeader(http.StatusInternalServerError)
-			_, _ = rw.Write([]byte("Internal server error"))
-			return
+	ctx, cancelFunc := p.detachedShutdownContext()
+	defer cancelFunc()
+
+	force := r.URL.Query().Get("force") == "true"
+	result, err := p.powerDownRemotesAndSelf(ctx, p.Config.WebAdmin.Remotes, force)
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot power off remotes and self"))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	var report bytes.Buffer
+	writeFleetReport(&report, result.All())
+	p.logFleetReport("poweroff-all-and-self", report.Bytes())
+
+	data := poweroffAllData{UriKey: p.Config.WebAdmin.UriKey}
+	for _, fr := range result.All() {
+		row := poweroffAllDataRow{Host: fr.Host, Outcome: fr.Outcome()}
+		if fr.Host == selfHost && fr.Err == nil {
+			row.Outcome = "powering off"
+		}
+		for _, hook := range fr.Hooks {
+			row.Hooks = append(row.Hooks, hook.String())
 		}
+		data.Rows = append(data.Rows, row)
+		data.AnyLocked = data.AnyLocked || errors.Is(fr.Err, ErrLocked)
 	}
 
-	if err := p.ExecutePoweroff(0); err != nil {
-		_ = p.Logger.Error(errors.Wrap(err, "cannot power off self"))
+	status := http.StatusOK
+	if err = fleetError(result.All()); err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot power off all remotes and self"))
+		status = http.StatusInternalServerError
+		if errors.Is(err, ErrLocked) {
+			status = http.StatusLocked
+		}
+	}
+
+	t, err := template.New("").Parse(poweroffAllTemplate)
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot parse poweroff-all template"))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	var body bytes.Buffer
+	if err = t.Execute(&body, data); err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot execute poweroff-all template"))
 		rw.WriteHeader(http.StatusInternalServerError)
 		_, _ = rw.Write([]byte("Internal server error"))
 		return
 	}
 
+	rw.Header().Set("Content-Type", "text/html")
+	rw.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	rw.WriteHeader(status)
+	_, _ = rw.Write(body.Bytes())
+	if result.Self.Err != nil {
+		return
+	}
+
+	// Deliver the results before this node goes down.
+	if flusher, ok := rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	if err = p.ExecutePoweroff(); err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot power off self"))
+	}
+}
+
+func (p *program) webadminExecuteRebootHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	remote, ok := p.remoteByHost(r.URL.Query().Get("host"))
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("Unknown host"))
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+	var report bytes.Buffer
+	hooks, err := p.RebootRemote(r.Context(), remote, force)
+	writeHookReport(&report, remote.Host, hooks)
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot reboot remote '%s'", remote.Host))
+		writePowerFailure(rw, "Cannot reboot "+remote.Host+".", err, report.Bytes())
+		return
+	}
+
 	rw.WriteHeader(http.StatusOK)
-	_, _ = rw.Write([]byte("Powering off remotes and self."))
+	_, _ = rw.Write([]byte("Rebooting " + remote.Host + ".\n\n"))
+	_, _ = rw.Write(report.Bytes())
 }
 
-func (p *program) nodeExecutePoweroffHandler(rw http.ResponseWriter, r *http.Request) {
-	defer p.recoverPanic(r.RequestURI)
+func (p *program) webadminExecuteRebootAllHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	ctx, cancelFunc := p.detachedFleetContext()
+	defer cancelFunc()
+
+	force := r.URL.Query().Get("force") == "true"
+	results := p.fanOutPower(ctx, p.Config.WebAdmin.Remotes, func(ctx context.Context, remote Remote) ([]hookResult, error) {
+		return p.RebootRemote(ctx, remote, force)
+	})
+
+	var report bytes.Buffer
+	writeFleetReport(&report, results)
+	p.logFleetReport("reboot-all", report.Bytes())
+	if err := fleetError(results); err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot reboot remotes"))
+		writePowerFailure(rw, "Cannot reboot all remotes.", err, report.Bytes())
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("Rebooting remotes.\n\n"))
+	_, _ = rw.Write(report.Bytes())
+}
+
+// powerDownRemotesAndSelf powers down the remotes level by level in dependency
+// order, following WebAdmin.PoweroffAllPolicy when one fails, and then runs the
+// pre-power hooks of this node. It does not power off this node, so that the
+// caller can deliver the results first; it may do so when Self.Err is nil.
+// Unless force is set, nodes with maintenance locks refuse.
+func (p *program) powerDownRemotesAndSelf(ctx context.Context, remotes []Remote, force bool) (poweroffAllResult, error) {
+	levels, err := shutdownLevels(p.Config.WebAdmin.Remotes, remotes)
+	if err != nil {
+		return poweroffAllResult{}, err
+	}
+
+	policy := p.Config.WebAdmin.PoweroffAllPolicy
+	result := poweroffAllResult{Self: fleetResult{Host: selfHost}}
+	result.Remotes = p.powerDownLevels(ctx, levels, scheduleActionPowerDown, force, policy != "" && policy != poweroffAllPolicyAbort)
+	if fleetError(result.Remotes) != nil {
+		switch policy {
+		case poweroffAllPolicyContinue:
+		case poweroffAllPolicyContinueKeepSelf:
+			result.Self.Err = errKeptUp
+			return result, nil
+		default:
+			result.Self.Err = errAborted
+			return result, nil
+		}
+	}
+
+	if !force {
+		locks, err := activeLocks()
+		if err != nil {
+			result.Self.Err = errors.Wrap(err, "cannot read maintenance locks")
+			return result, nil
+		}
+		if len(locks) > 0 {
+			result.Self.Err = errors.WithMessage(ErrLocked, lockReport(locks))
+			return result, nil
+		}
+	}
+
+	var ok bool
+	result.Self.Hooks, ok = p.runPowerHooks("poweroff")
+	if !ok {
+		result.Self.Err = ErrHookAborted
+	}
+
+	return result, nil
+}
+
+// logFleetReport logs the report of a fleet operation started from the webadmin,
+// as the client that started it may have disconnected before it finished.
+func (p *program) logFleetReport(operation string, report []byte) {
+	if len(report) > 0 {
+		_ = p.Logger.Infof("results of %s:\n%s", operation, report)
+	}
+}
+
+// writeHookReport appends a line per pre-power hook result of host to report.
+func writeHookReport(report *bytes.Buffer, host string, hooks []hookResult) {
+	for _, hook := range hooks {
+		_, _ = fmt.Fprintf(report, "%s: %s\n", host, hook)
+	}
+}
 
+// writePowerFailure answers a failed webadmin power action. Refusals because of
+// maintenance locks and aborts by pre-power hooks are explained, other errors
+// are only logged.
+func writePowerFailure(rw http.ResponseWriter, message string, err error, report []byte) {
+	if errors.Is(err, ErrLocked) {
+		rw.WriteHeader(http.StatusLocked)
+		_, _ = rw.Write([]byte(message + " " + err.Error() + "\n\nUse the force action on the dashboard to ignore the locks.\n"))
+		return
+	}
+
+	rw.WriteHeader(http.StatusInternalServerError)
+	if !errors.Is(err, ErrHookAborted) {
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	_, _ = rw.Write([]byte(message + " A pre-power hook failed.\n\n"))
+	_, _ = rw.Write(report)
+}
+
+func (p *program) webadminExecutePoweronHandler(rw http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		rw.WriteHeader(http.StatusMethodNotAllowed)
 		_, _ = rw.Write([]byte("HTTP method not allowed"))
 		return
 	}
 
-	var action nodePoweroffAction
-	if !p.verifyNodeRequest(&action, rw, r) {
+	remote, ok := p.remoteByHost(r.URL.Query().Get("host"))
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("Unknown host"))
 		return
 	}
 
-	if action.Async {
-		go func() {
-			if err := p.ExecutePoweroff(action.PoweroffDelayMsec); err != nil {
-				_ = p.Logger.Error(errors.Wrap(err, "cannot execute poweroff").Error())
-			}
-		}()
+	if err := p.PoweronRemote(remote); err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot power on remote '%s'", remote.Host))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
 
-		rw.WriteHeader(http.StatusOK)
-		_, _ = rw.Write([]byte("OK async"))
-	} else {
-		if err := p.ExecutePoweroff(action.PoweroffDelayMsec); err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			_, _ = rw.Write([]byte(errors.Wrap(err, "cannot execute poweroff").Error()))
-			return
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("Sent Wake-on-LAN packet to " + remote.Host + "."))
+}
+
+func (p *program) webadminExecutePoweronAllHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	ctx, cancelFunc := p.detachedFleetContext()
+	defer cancelFunc()
+
+	var remotes []Remote
+	for _, remote := range p.Config.WebAdmin.Remotes {
+		if remote.MAC != "" {
+			remotes = append(remotes, remote)
 		}
+	}
 
-		rw.WriteHeader(http.StatusOK)
-		_, _ = rw.Write([]byte("OK"))
+	// One remote that cannot be woken must not keep the others down.
+	results := p.fanOutPower(ctx, remotes, func(ctx context.Context, remote Remote) ([]hookResult, error) {
+		return nil, p.PoweronRemote(remote)
+	})
+
+	var report bytes.Buffer
+	writeFleetReport(&report, results)
+	p.logFleetReport("poweron-all", report.Bytes())
+
+	rw.Header().Set("Content-Type", "text/plain")
+	if err := fleetError(results); err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot power on remotes"))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Cannot power on all remotes.\n\n"))
+		_, _ = rw.Write(report.Bytes())
+		return
 	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("Sent Wake-on-LAN packets.\n\n"))
+	_, _ = rw.Write(report.Bytes())
 }
 
-func (p *program) nodeHealthHandler(rw http.ResponseWriter, r *http.Request) {
-	defer p.recoverPanic(r.RequestURI)
+func (p *program) webadminExecuteCancelHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	remote, ok := p.remoteByHost(r.URL.Query().Get("host"))
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("Unknown host"))
+		return
+	}
+
+	if err := p.CancelRemotePending(r.Context(), remote, r.URL.Query().Get("id")); err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot cancel pending power action on remote '%s'", remote.Host))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("Cancelled pending power action on " + remote.Host + "."))
+}
 
+func (p *program) webadminExecuteCommandHandler(rw http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		rw.WriteHeader(http.StatusMethodNotAllowed)
 		_, _ = rw.Write([]byte("HTTP method not allowed"))
 		return
 	}
 
-	var action nodeHealthAction
-	if !p.verifyNodeRequest(&action, rw, r) {
+	remote, ok := p.remoteByHost(r.URL.Query().Get("host"))
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("Unknown host"))
 		return
 	}
 
-	data, err := json.Marshal(nodeHealthResponse{Status: "online"})
+	name := r.URL.Query().Get("name")
+	resp, err := p.RunRemoteCommand(r.Context(), remote, name)
 	if err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot run command '%s' on remote '%s'", name, remote.Host))
 		rw.WriteHeader(http.StatusInternalServerError)
-		_ = p.Logger.Error(errors.Wrap(err, "cannot create health response"))
+		_, _ = rw.Write([]byte(err.Error()))
 		return
 	}
 
-	rw.Header().Set("Content-Type", "application/json")
+	var body bytes.Buffer
+	_, _ = fmt.Fprintf(&body, "Command '%s' on %s exited with code %d", name, remote.Host, resp.ExitCode)
+	if resp.TimedOut {
+		body.WriteString(" after timing out")
+	}
+	_, _ = fmt.Fprintf(&body, ".\n\n--- stdout ---\n%s\n--- stderr ---\n%s\n", resp.Stdout, resp.Stderr)
+
+	rw.Header().Set("Content-Type", "text/plain")
 	rw.WriteHeader(http.StatusOK)
-	_, _ = rw.Write(data)
+	_, _ = rw.Write(body.Bytes())
 }
 
-func (p *program) verifyNodeRequest(action actionInterface, rw http.ResponseWriter, r *http.Request) bool {
-	signatureBytes := r.Header.Get("X-Signature")
-	if signatureBytes == "" {
-		rw.WriteHeader(http.StatusUnauthorized)
-		_, _ = rw.Write([]byte("Unauthorized"))
-		return false
+func (p *program) webadminExecuteSkipScheduleHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
 	}
 
-	dec := base64.NewDecoder(base64.StdEncoding, bytes.NewBufferString(signatureBytes))
-	signature, err := io.ReadAll(dec)
-	if err != nil {
-		rw.WriteHeader(http.StatusUnauthorized)
-		_, _ = rw.Write([]byte("Unauthorized"))
-		return false
+	rule := r.URL.Query().Get("rule")
+	skip := r.URL.Query().Get("skip") != "false"
+	if !p.scheduler.SkipNext(rule, skip) {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("Unknown schedule rule"))
+		return
 	}
 
-	var reqBody bytes.Buffer
-	if _, err = io.Copy(&reqBody, r.Body); err != nil {
-		_ = p.Logger.Error(errors.Wrap(err, "cannot read request body"))
-		rw.WriteHeader(http.StatusBadRequest)
-		_, _ = rw.Write([]byte("Bad request"))
-		return false
+	rw.WriteHeader(http.StatusOK)
+	if skip {
+		_, _ = rw.Write([]byte("Skipping the next run of " + rule + "."))
+	} else {
+		_, _ = rw.Write([]byte("Not skipping the next run of " + rule + "."))
 	}
+}
 
-	message := reqBody.Bytes()
-	var verified bool
-	for _, key := range p.Config.authorizedKeys {
-		if err = verifyMessage(message, signature, key); err == nil {
-			verified = true
-			break
-		}
+func (p *program) webadminExecuteKeepAwakeHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
 	}
-	if !verified {
-		rw.WriteHeader(http.StatusUnauthorized)
-		_, _ = rw.Write([]byte("Unauthorized"))
-		return false
+
+	remote, ok := p.remoteByHost(r.URL.Query().Get("host"))
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("Unknown host"))
+		return
 	}
 
-	if err = json.Unmarshal(message, &action); err != nil {
+	durationSec, err := strconv.Atoi(r.URL.Query().Get("sec"))
+	if err != nil || durationSec < 0 {
 		rw.WriteHeader(http.StatusBadRequest)
-		_, _ = rw.Write([]byte(errors.Wrap(err, "cannot JSON decode action").Error()))
-		return false
+		_, _ = rw.Write([]byte("Invalid duration"))
+		return
 	}
 
-	var t time.Time
-	t, err = action.ParseCurrentTime()
-	if err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
-		_, _ = rw.Write([]byte(errors.Wrap(err, "cannot parse current time").Error()))
-		return false
+	duration := time.Duration(durationSec) * time.Second
+	if err = p.KeepRemoteAwake(r.Context(), remote, duration); err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot keep remote '%s' awake", remote.Host))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	if duration == 0 {
+		_, _ = rw.Write([]byte("Allowing " + remote.Host + " to power off when idle."))
+	} else {
+		_, _ = rw.Write([]byte("Keeping " + remote.Host + " awake for " + duration.String() + "."))
+	}
+}
+
+// webadminLockName is the name of the maintenance locks taken from the webadmin.
+const webadminLockName = "webadmin"
+
+func (p *program) webadminExecuteLockHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	remote, ok := p.remoteByHost(r.URL.Query().Get("host"))
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("Unknown host"))
+		return
 	}
 
-	if math.Abs(time.Now().Sub(t).Minutes()) >= 1 {
+	ttlSec, err := strconv.Atoi(r.FormValue("ttl"))
+	if err != nil || ttlSec < 0 {
 		rw.WriteHeader(http.StatusBadRequest)
-		_, _ = rw.Write([]byte(errors.Wrap(err, "current time deviates too far").Error()))
-		return false
+		_, _ = rw.Write([]byte("Invalid TTL"))
+		return
 	}
 
-	return true
+	ttl := time.Duration(ttlSec) * time.Second
+	if err = p.LockRemote(r.Context(), remote, webadminLockName, "webadmin", r.FormValue("reason"), ttl); err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot lock remote '%s'", remote.Host))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("Locked " + remote.Host + "."))
+}
+
+func (p *program) webadminExecuteUnlockHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	remote, ok := p.remoteByHost(r.URL.Query().Get("host"))
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("Unknown host"))
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if err := p.UnlockRemote(r.Context(), remote, name); err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot release lock '%s' of remote '%s'", name, remote.Host))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("Released lock '" + name + "' of " + remote.Host + "."))
+}
+
+// idleStatus describes the idle status of a remote in the dashboard.
+func idleStatus(status nodeIdleStatus) string {
+	switch {
+	case status.KeepAwakeUntil != "":
+		return "kept awake until " + status.KeepAwakeUntil
+	case len(status.BusyReasons) > 0:
+		return "busy: " + strings.Join(status.BusyReasons, ", ")
+	case status.IdleSec > 0:
+		return fmt.Sprintf("idle for %ds, poweroff in %ds", status.IdleSec, status.PoweroffInSec)
+	default:
+		return "not checked yet"
+	}
+}
+
+// revocationStatus describes whether a remote has the newest revocation list of
+// every signer in versions.
+func revocationStatus(nhr nodeHealthResponse, versions map[string]int) string {
+	if nhr.Status != "online" {
+		return "unknown"
+	}
+
+	var outdated []string
+	for keyID, version := range versions {
+		if remoteVersion := nhr.RevocationVersions[keyID]; remoteVersion < version {
+			outdated = append(outdated, fmt.Sprintf("%s: version %d of %d", keyID, remoteVersion, version))
+		}
+	}
+	if len(outdated) > 0 {
+		sort.Strings(outdated)
+		return "outdated (" + strings.Join(outdated, ", ") + ")"
+	}
+
+	return "up to date"
+}
+
+func (p *program) webadminExecuteDistributeRevocationsHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	var report bytes.Buffer
+	ctx, cancelFunc := p.detachedFleetContext()
+	defer cancelFunc()
+
+	failed := p.distributeRevocations(ctx, func(remote Remote, err error) {
+		if err != nil {
+			_ = p.Logger.Error(errors.Wrapf(err, "cannot send revocation list to remote '%s'", remote.Host))
+			_, _ = fmt.Fprintf(&report, "%s: failed: %v\n", remote.Host, err)
+		} else {
+			_, _ = fmt.Fprintf(&report, "%s: received\n", remote.Host)
+		}
+	})
+	p.logFleetReport("distribute-revocations", report.Bytes())
+
+	rw.Header().Set("Content-Type", "text/plain")
+	if failed > 0 {
+		rw.WriteHeader(http.StatusInternalServerError)
+	} else {
+		rw.WriteHeader(http.StatusOK)
+	}
+	_, _ = rw.Write(report.Bytes())
+}
+
+func (p *program) nodeExecutePoweroffHandler(rw http.ResponseWriter, r *http.Request) {
+	defer p.recoverPanic(r.RequestURI)
+
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	var action nodePoweroffAction
+	if !p.verifyNodeRequest(&action, rw, r) {
+		return
+	}
+
+	p.executePowerAction(rw, action.ActionType(), action.Async, action.Force, action.PoweroffDelayMsec, p.ExecutePoweroff)
+}
+
+func (p *program) nodeExecuteRebootHandler(rw http.ResponseWriter, r *http.Request) {
+	defer p.recoverPanic(r.RequestURI)
+
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	var action nodeRebootAction
+	if !p.verifyNodeRequest(&action, rw, r) {
+		return
+	}
+
+	p.executePowerAction(rw, action.ActionType(), action.Async, action.Force, action.RebootDelayMsec, p.ExecuteReboot)
+}
+
+func (p *program) nodeExecuteSleepHandler(sleepAction string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		defer p.recoverPanic(r.RequestURI)
+
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			_, _ = rw.Write([]byte("HTTP method not allowed"))
+			return
+		}
+
+		action := nodeSleepAction{Action: sleepAction}
+		if !p.verifyNodeRequest(&action, rw, r) {
+			return
+		}
+
+		supported, err := supportedSleepActions()
+		if err != nil {
+			_ = p.Logger.Error(errors.Wrap(err, "cannot determine supported sleep states"))
+		}
+		if !containsString(supported, sleepAction) {
+			rw.WriteHeader(http.StatusNotImplemented)
+			_, _ = rw.Write([]byte("Sleep state '" + sleepAction + "' is not supported"))
+			return
+		}
+
+		p.executePowerAction(rw, sleepAction, action.Async, action.Force, action.SleepDelayMsec, func() error {
+			return p.ExecuteSleep(sleepAction)
+		})
+	}
+}
+
+// executePowerAction runs the pre-power hooks and schedules execute to run after
+// delayMsec. Until then it can be cancelled. Async requests are answered right
+// away, others once the action has run. Unless forced, the action is refused
+// while the node holds maintenance locks.
+func (p *program) executePowerAction(rw http.ResponseWriter, name string, async bool, force bool, delayMsec int, execute func() error) {
+	locks, err := activeLocks()
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot read maintenance locks"))
+		if !force {
+			rw.WriteHeader(http.StatusInternalServerError)
+			_, _ = rw.Write([]byte("Cannot read maintenance locks"))
+			return
+		}
+	}
+	if len(locks) > 0 {
+		if !force {
+			_ = p.Logger.Warningf("refusing %s, node is locked: %s", name, lockReport(locks))
+			rw.WriteHeader(http.StatusLocked)
+			_, _ = rw.Write([]byte("Node is locked: " + lockReport(locks)))
+			return
+		}
+
+		_ = p.Logger.Warningf("forcing %s despite locks: %s", name, lockReport(locks))
+	}
+
+	hooks, ok := p.runPowerHooks(name)
+	if !ok {
+		p.writePowerResponse(rw, http.StatusFailedDependency, nodePowerResponse{Status: "Aborted by pre-power hook", Hooks: hooks})
+		return
+	}
+
+	var op *pendingOperation
+	op, err = p.pending.Schedule(name, time.Duration(delayMsec)*time.Millisecond, execute)
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot schedule %s", name))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	if async {
+		go func() {
+			if err := op.Wait(); err != nil {
+				if err == errPowerActionCancelled {
+					_ = p.Logger.Infof("%s was cancelled", name)
+				} else {
+					_ = p.Logger.Error(errors.Wrapf(err, "cannot execute %s", name).Error())
+				}
+			}
+		}()
+
+		p.writePowerResponse(rw, http.StatusOK, nodePowerResponse{Status: "OK async", Hooks: hooks})
+	} else {
+		if err = op.Wait(); err != nil {
+			if err == errPowerActionCancelled {
+				rw.WriteHeader(http.StatusGone)
+				_, _ = rw.Write([]byte("Cancelled"))
+				return
+			}
+
+			rw.WriteHeader(http.StatusInternalServerError)
+			_, _ = rw.Write([]byte(errors.Wrapf(err, "cannot execute %s", name).Error()))
+			return
+		}
+
+		p.writePowerResponse(rw, http.StatusOK, nodePowerResponse{Status: "OK", Hooks: hooks})
+	}
+}
+
+func (p *program) writePowerResponse(rw http.ResponseWriter, statusCode int, resp nodePowerResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		_ = p.Logger.Error(errors.Wrap(err, "cannot create power action response"))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(statusCode)
+	_, _ = rw.Write(data)
+}
+
+func (p *program) nodeExecuteCancelHandler(rw http.ResponseWriter, r *http.Request) {
+	defer p.recoverPanic(r.RequestURI)
+
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	var action nodeCancelAction
+	if !p.verifyNodeRequest(&action, rw, r) {
+		return
+	}
+
+	cancelled := p.pending.Cancel(action.ID)
+	if cancelled == 0 {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("No pending power action to cancel"))
+		return
+	}
+
+	_ = p.Logger.Infof("cancelled %d pending power actions", cancelled)
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte(fmt.Sprintf("Cancelled %d pending power actions", cancelled)))
+}
+
+func (p *program) nodeExecuteCommandHandler(rw http.ResponseWriter, r *http.Request) {
+	defer p.recoverPanic(r.RequestURI)
+
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/node/execute/command/")
+	command, ok := p.Config.Node.Commands[name]
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("Unknown command"))
+		return
+	}
+
+	action := nodeCommandAction{Name: name, Scopes: command.scopes(name)}
+	if !p.verifyNodeRequest(&action, rw, r) {
+		return
+	}
+
+	resp, err := command.run()
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot run command '%s'", name))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte(errors.Wrapf(err, "cannot run command '%s'", name).Error()))
+		return
+	}
+
+	_ = p.Logger.Infof("command '%s' exited with code %d", name, resp.ExitCode)
+
+	var data []byte
+	data, err = json.Marshal(resp)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		_ = p.Logger.Error(errors.Wrap(err, "cannot create command response"))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(data)
+}
+
+func (p *program) nodeLockHandler(rw http.ResponseWriter, r *http.Request) {
+	defer p.recoverPanic(r.RequestURI)
+
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	var action nodeLockAction
+	if !p.verifyNodeRequest(&action, rw, r) {
+		return
+	}
+
+	owner := action.Owner
+	if owner == "" {
+		// verifyNodeRequest has checked that this key signed the request.
+		owner = "key " + r.Header.Get("X-Key-Id")
+	}
+
+	lock, err := newMaintenanceLock(action.Name, owner, action.Reason, time.Duration(action.TTLSec)*time.Second)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(err.Error()))
+		return
+	}
+
+	if err = acquireLock(lock); err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot acquire lock '%s'", lock.Name))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	_ = p.Logger.Infof("acquired lock %s", lock)
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("OK"))
+}
+
+func (p *program) nodeUnlockHandler(rw http.ResponseWriter, r *http.Request) {
+	defer p.recoverPanic(r.RequestURI)
+
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	var action nodeUnlockAction
+	if !p.verifyNodeRequest(&action, rw, r) {
+		return
+	}
+
+	released, err := releaseLock(action.Name)
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot release lock '%s'", action.Name))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	if !released {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte("Lock is not held"))
+		return
+	}
+
+	_ = p.Logger.Infof("released lock '%s'", action.Name)
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("OK"))
+}
+
+func (p *program) nodeKeepAwakeHandler(rw http.ResponseWriter, r *http.Request) {
+	defer p.recoverPanic(r.RequestURI)
+
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	var action nodeKeepAwakeAction
+	if !p.verifyNodeRequest(&action, rw, r) {
+		return
+	}
+
+	if action.DurationSec < 0 {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte("duration must not be negative"))
+		return
+	}
+
+	if action.DurationSec == 0 {
+		p.idle.KeepAwake(time.Time{})
+		_ = p.Logger.Info("keep awake ended by controller")
+	} else {
+		duration := time.Duration(action.DurationSec) * time.Second
+		p.idle.KeepAwake(time.Now().Add(duration))
+		_ = p.Logger.Infof("kept awake by controller for %s", duration)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("OK"))
+}
+
+func (p *program) nodeHealthHandler(rw http.ResponseWriter, r *http.Request) {
+	defer p.recoverPanic(r.RequestURI)
+
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	var action nodeHealthAction
+	if !p.verifyNodeRequest(&action, rw, r) {
+		return
+	}
+
+	sleepStates, err := supportedSleepActions()
+	if err != nil {
+		_ = p.Logger.Warning(errors.Wrap(err, "cannot determine supported sleep states"))
+	}
+
+	nhr := nodeHealthResponse{
+		Status:             "online",
+		RevocationVersions: p.Config.revocations.Versions(),
+		SleepStates:        sleepStates,
+		PendingActions:     p.pending.List(),
+		Commands:           p.commandNames(),
+	}
+	nhr.Locks, err = activeLocks()
+	if err != nil {
+		_ = p.Logger.Warning(errors.Wrap(err, "cannot read maintenance locks"))
+	}
+	if p.Config.Node.Idle.PoweroffAfterSec > 0 {
+		nhr.Idle = p.idle.Status(time.Duration(p.Config.Node.Idle.PoweroffAfterSec) * time.Second)
+	}
+
+	var data []byte
+	data, err = json.Marshal(nhr)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		_ = p.Logger.Error(errors.Wrap(err, "cannot create health response"))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(data)
+}
+
+func (p *program) nodeRotateKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	defer p.recoverPanic(r.RequestURI)
+
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	var action nodeRotateKeyAction
+	if !p.verifyNodeRequest(&action, rw, r) {
+		return
+	}
+
+	if action.GracePeriodSec <= 0 {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte("grace period must be positive"))
+		return
+	}
+
+	newKey, newKeyID, err := parsePublicKeyPEM(action.NewPublicKey)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(errors.Wrap(err, "cannot parse new public key").Error()))
+		return
+	}
+
+	if p.Config.revocations.IsRevoked(newKeyID) {
+		rw.WriteHeader(http.StatusForbidden)
+		_, _ = rw.Write([]byte("new key has been revoked"))
+		return
+	}
+
+	// verifyNodeRequest has checked that this key signed the request.
+	oldKeyID := r.Header.Get("X-Key-Id")
+	gracePeriod := time.Duration(action.GracePeriodSec) * time.Second
+	var added authorizedKey
+	added, err = p.Config.authorizedKeys.Rotate(oldKeyID, newKey, gracePeriod)
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "cannot rotate authorized key '%s'", oldKeyID))
+		rw.WriteHeader(http.StatusInternalServerError)
+		_, _ = rw.Write([]byte("Internal server error"))
+		return
+	}
+
+	_ = p.Logger.Infof("authorized key '%s' (%s) replaces '%s', which expires in %s", added.Path, newKeyID, oldKeyID, gracePeriod)
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("OK"))
+}
+
+func (p *program) nodeRevocationsHandler(rw http.ResponseWriter, r *http.Request) {
+	defer p.recoverPanic(r.RequestURI)
+
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = rw.Write([]byte("HTTP method not allowed"))
+		return
+	}
+
+	var action nodeRevocationsAction
+	if !p.verifyNodeRequest(&action, rw, r) {
+		return
+	}
+
+	applied, err := p.Config.revocations.Apply(action.List, p.Config.authorizedKeys)
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot apply revocation list"))
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(errors.Wrap(err, "cannot apply revocation list").Error()))
+		return
+	}
+
+	version := p.Config.revocations.VersionBy(action.List.KeyID)
+	if !applied {
+		rw.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(rw, "Already has version %d", version)
+		return
+	}
+
+	_ = p.Logger.Infof("applied revocation list version %d of key '%s'", version, action.List.KeyID)
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("OK"))
+}
+
+func (p *program) verifyNodeRequest(action actionInterface, rw http.ResponseWriter, r *http.Request) bool {
+	signatureBytes := r.Header.Get("X-Signature")
+	if signatureBytes == "" {
+		rw.WriteHeader(http.StatusUnauthorized)
+		_, _ = rw.Write([]byte("Unauthorized"))
+		return false
+	}
+
+	dec := base64.NewDecoder(base64.StdEncoding, bytes.NewBufferString(signatureBytes))
+	signature, err := io.ReadAll(dec)
+	if err != nil {
+		rw.WriteHeader(http.StatusUnauthorized)
+		_, _ = rw.Write([]byte("Unauthorized"))
+		return false
+	}
+
+	var reqBody bytes.Buffer
+	if _, err = io.Copy(&reqBody, r.Body); err != nil {
+		_ = p.Logger.Error(errors.Wrap(err, "cannot read request body"))
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte("Bad request"))
+		return false
+	}
+
+	message := reqBody.Bytes()
+	keyID := r.Header.Get("X-Key-Id")
+	key, ok := p.Config.authorizedKeys.Get(keyID)
+	if !ok || p.Config.revocations.IsRevoked(keyID) {
+		rw.WriteHeader(http.StatusUnauthorized)
+		_, _ = rw.Write([]byte("Unauthorized"))
+		return false
+	}
+
+	// A TLS client certificate, when present, must be for the same key that
+	// signed the request.
+	var certKeyID string
+	certKeyID, err = clientCertificateKeyID(r)
+	if err != nil || (certKeyID == "" && p.Config.Node.RequireClientCertificate) || (certKeyID != "" && certKeyID != keyID) {
+		rw.WriteHeader(http.StatusUnauthorized)
+		_, _ = rw.Write([]byte("Unauthorized"))
+		return false
+	}
+
+	if err = verifyMessage(message, signature, key.Key); err != nil {
+		rw.WriteHeader(http.StatusUnauthorized)
+		_, _ = rw.Write([]byte("Unauthorized"))
+		return false
+	}
+
+	var envelope nodeRequestEnvelope
+	if err = json.Unmarshal(message, &envelope); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(errors.Wrap(err, "cannot JSON decode request envelope").Error()))
+		return false
+	}
+
+	if err = p.checkRequestEnvelope(envelope, action.ActionType(), r); err != nil {
+		_ = p.Logger.Warning(errors.Wrapf(err, "rejected signed request for '%s'", r.URL.Path))
+		rw.WriteHeader(http.StatusUnauthorized)
+		_, _ = rw.Write([]byte("Unauthorized"))
+		return false
+	}
+
+	scopes := []string{envelope.ActionType}
+	if sa, ok := action.(scopedAction); ok {
+		scopes = sa.RequiredScopes()
+	}
+	if !key.HasAnyScope(scopes) {
+		_ = p.Logger.Warningf("action '%s' is not in the scopes of authorized key '%s'", envelope.ActionType, key.Path)
+		rw.WriteHeader(http.StatusForbidden)
+		_, _ = rw.Write([]byte("Forbidden"))
+		return false
+	}
+
+	if err = json.Unmarshal(envelope.Action, action); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(errors.Wrap(err, "cannot JSON decode action").Error()))
+		return false
+	}
+
+	if fa, ok := action.(forcibleAction); ok && fa.Forced() && !key.HasScope(forceScope) {
+		_ = p.Logger.Warningf("forcing '%s' is not in the scopes of authorized key '%s'", envelope.ActionType, key.Path)
+		rw.WriteHeader(http.StatusForbidden)
+		_, _ = rw.Write([]byte("Forbidden"))
+		return false
+	}
+
+	var t time.Time
+	t, err = action.ParseCurrentTime()
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte(errors.Wrap(err, "cannot parse current time").Error()))
+		return false
+	}
+
+	if math.Abs(float64(time.Since(t))) >= float64(requestTimeWindow) {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte("current time deviates too far"))
+		return false
+	}
+
+	requestID := action.GetRequestID()
+	if requestID == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		_, _ = rw.Write([]byte("no request ID set"))
+		return false
+	}
+
+	if err = p.nonces.Add(requestID, t.Add(requestTimeWindow)); err != nil {
+		if err == errReplayedRequest {
+			_ = p.Logger.Warningf("rejected replayed request '%s' for '%s'", requestID, r.URL.Path)
+			rw.WriteHeader(http.StatusConflict)
+			_, _ = rw.Write([]byte("Replayed request"))
+			return false
+		}
+
+		_ = p.Logger.Error(errors.Wrap(err, "cannot remember request ID"))
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = rw.Write([]byte("Service unavailable"))
+		return false
+	}
+
+	_ = p.Logger.Infof("action '%s' approved by authorized key '%s'", envelope.ActionType, key.Path)
+	return true
+}
+
+// checkRequestEnvelope makes sure the signed envelope was created for exactly
+// this action type, HTTP method, path and node.
+func (p *program) checkRequestEnvelope(envelope nodeRequestEnvelope, actionType string, r *http.Request) error {
+	if envelope.ActionType != actionType {
+		return errors.Errorf("action type '%s' does not match expected '%s'", envelope.ActionType, actionType)
+	}
+	if envelope.Method != r.Method {
+		return errors.Errorf("method '%s' does not match request method '%s'", envelope.Method, r.Method)
+	}
+	if envelope.Path != r.URL.Path {
+		return errors.Errorf("path '%s' does not match request path '%s'", envelope.Path, r.URL.Path)
+	}
+	if !p.isSelfHost(envelope.Host, r) {
+		return errors.Errorf("target host '%s' is not this node", envelope.Host)
+	}
+
+	return nil
+}
+
+// isSelfHost reports whether host names this node. Without configured host
+// names the Host header of the request is the only reference available.
+func (p *program) isSelfHost(host string, r *http.Request) bool {
+	if host == "" {
+		return false
+	}
+
+	if len(p.Config.Node.Hostnames) > 0 {
+		for _, hostname := range p.Config.Node.Hostnames {
+			if strings.EqualFold(hostname, host) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	requestHost, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		requestHost = r.Host
+	}
+
+	return strings.EqualFold(requestHost, host)
 }
 
 func (p *program) recoverPanic(requestURI string) {
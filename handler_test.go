@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifyNodeRequest(t *testing.T) {
+	p := newTestNode(t)
+
+	rec := serveNodeRequest(t, p, p.nodeHealthHandler, "/node/health", &nodeHealthAction{})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+
+	unsigned, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:"+HTTPPort+"/node/health", bytes.NewBufferString("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec = serveRequest(p.nodeHealthHandler, unsigned); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	other := newTestNode(t)
+	if rec = serveNodeRequest(t, other, p.nodeHealthHandler, "/node/health", &nodeHealthAction{}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthorized key: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestVerifyNodeRequestKeyID(t *testing.T) {
+	p := newTestNode(t)
+	other := newTestKey(t)
+	otherKeyID := testKeyID(t, other.Public())
+	p.Config.authorizedKeys.keys[otherKeyID] = authorizedKey{Path: "other.pub", Key: other.Public()}
+
+	tests := []struct {
+		name  string
+		keyID string
+	}{
+		{"no key ID", ""},
+		{"unknown key ID", "SHA256:unknown"},
+		{"key ID of another authorized key", otherKeyID},
+	}
+
+	for _, test := range tests {
+		req, _, err := p.newNodeRequest(context.Background(), Remote{Host: "127.0.0.1"}, "/node/health", &nodeHealthAction{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("X-Key-Id", test.keyID)
+		if rec := serveRequest(p.nodeHealthHandler, req); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d, want %d", test.name, rec.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestVerifyNodeRequestScopes(t *testing.T) {
+	tests := []struct {
+		scopes map[string]bool
+		want   int
+	}{
+		{nil, http.StatusOK},
+		{map[string]bool{"health": true}, http.StatusOK},
+		{map[string]bool{"*": true}, http.StatusOK},
+		{map[string]bool{"poweroff": true}, http.StatusForbidden},
+		{map[string]bool{}, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		p := newTestNode(t)
+		key := p.Config.authorizedKeys.keys[p.Config.selfKeyID]
+		key.Scopes = test.scopes
+		p.Config.authorizedKeys.keys[p.Config.selfKeyID] = key
+
+		if rec := serveNodeRequest(t, p, p.nodeHealthHandler, "/node/health", &nodeHealthAction{}); rec.Code != test.want {
+			t.Errorf("scopes %v: got status %d, want %d", test.scopes, rec.Code, test.want)
+		}
+	}
+}
+
+func TestVerifyNodeRequestEnvelope(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *program, req *http.Request)
+	}{
+		{"tampered body", func(p *program, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+			body = bytes.Replace(body, []byte(`"health"`), []byte(`"poweroff"`), 1)
+			req.Body = io.NopCloser(bytes.NewBuffer(body))
+		}},
+		{"other path", func(p *program, req *http.Request) {
+			req.URL.Path = "/node/health/"
+		}},
+		{"other host", func(p *program, req *http.Request) {
+			req.Host = "10.0.0.2:" + HTTPPort
+		}},
+		{"not a configured host name", func(p *program, req *http.Request) {
+			p.Config.Node.Hostnames = []string{"node1.example.com"}
+		}},
+	}
+
+	for _, test := range tests {
+		p := newTestNode(t)
+		req, _, err := p.newNodeRequest(context.Background(), Remote{Host: "127.0.0.1"}, "/node/health", &nodeHealthAction{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		test.modify(p, req)
+		if rec := serveRequest(p.nodeHealthHandler, req); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d, want %d", test.name, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// A poweroff request must not pass as another action, like health.
+	p := newTestNode(t)
+	if rec := serveNodeRequest(t, p, p.nodeHealthHandler, "/node/health", &nodePoweroffAction{}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("other action type: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	// The target may be any of the configured host names.
+	p.Config.Node.Hostnames = []string{"node1.example.com", "127.0.0.1"}
+	if rec := serveNodeRequest(t, p, p.nodeHealthHandler, "/node/health", &nodeHealthAction{}); rec.Code != http.StatusOK {
+		t.Errorf("configured host name: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestVerifyNodeRequestReplay(t *testing.T) {
+	p := newTestNode(t)
+	req, _, err := p.newNodeRequest(context.Background(), Remote{Host: "127.0.0.1"}, "/node/health", &nodeHealthAction{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []int{http.StatusOK, http.StatusConflict} {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		if rec := serveRequest(p.nodeHealthHandler, req); rec.Code != want {
+			t.Errorf("request %d: got status %d, want %d", i+1, rec.Code, want)
+		}
+	}
+}
+
+func TestWebadminDashboardEscapes(t *testing.T) {
+	p := newTestNode(t)
+	p.Config.WebAdmin.UriKey = "key"
+	p.Config.WebAdmin.Remotes = []Remote{{Host: `x"><script>alert(1)</script>`, KeyID: "SHA256:x"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/webadmin/?key=key", nil)
+	rec := serveRequest(p.webadminDashboardHandler, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+
+	body := rec.Body.String()
+	if strings.Contains(body, "<script>alert(1)") {
+		t.Error("host is not escaped")
+	}
+	if !strings.Contains(body, "<td>x&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;</td>") {
+		t.Error("host is not shown as text")
+	}
+	if !strings.Contains(body, "host=x%22%3e%3cscript%3ealert%281%29%3c%2fscript%3e") {
+		t.Error("host is not escaped as query parameter")
+	}
+}
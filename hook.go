@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultHookTimeout = time.Minute
+
+	// Maximum number of bytes of stdout and of stderr kept per hook, so the
+	// results of all hooks fit in a power action response.
+	maxHookOutputSize = 64 << 10
+)
+
+// PowerHook is a command that runs before a power action, like stopping units,
+// unmounting network shares or flushing a database. Hooks run when the request
+// arrives, before the delay of the action, so that their results can be
+// returned. Cancelling the action does not undo them.
+type PowerHook struct {
+	Name string
+	Argv []string
+
+	// Defaults to one minute.
+	TimeoutSec int
+
+	// Whether a failing hook aborts the power action. Otherwise the remaining
+	// hooks and the power action run anyway.
+	AbortOnFailure bool
+
+	// Power actions the hook runs before, like "poweroff" or "suspend".
+	// Defaults to all of them.
+	Actions []string
+}
+
+func (h PowerHook) runsBefore(action string) bool {
+	return len(h.Actions) == 0 || containsString(h.Actions, action)
+}
+
+type hookResult struct {
+	Name         string `json:"Name"`
+	ExitCode     int    `json:"ExitCode"`
+	TimedOut     bool   `json:"TimedOut"`
+	Error        string `json:"Error,omitempty"`
+	Stdout       string `json:"Stdout"`
+	Stderr       string `json:"Stderr"`
+	DurationMsec int64  `json:"DurationMsec"`
+}
+
+func (r hookResult) Failed() bool {
+	return r.Error != "" || r.TimedOut || r.ExitCode != 0
+}
+
+func (r hookResult) String() string {
+	switch {
+	case r.Error != "":
+		return fmt.Sprintf("hook '%s' failed: %s", r.Name, r.Error)
+	case r.TimedOut:
+		return fmt.Sprintf("hook '%s' timed out after %d ms", r.Name, r.DurationMsec)
+	default:
+		return fmt.Sprintf("hook '%s' exited with code %d after %d ms", r.Name, r.ExitCode, r.DurationMsec)
+	}
+}
+
+// runPowerHooks runs the hooks for action in their configured order. It stops at
+// the first failing hook with AbortOnFailure and returns false, in which case
+// the power action must not run.
+func (p *program) runPowerHooks(action string) ([]hookResult, bool) {
+	results := make([]hookResult, 0)
+	for _, hook := range p.Config.Node.PrePowerHooks {
+		if !hook.runsBefore(action) {
+			continue
+		}
+
+		timeout := defaultHookTimeout
+		if hook.TimeoutSec > 0 {
+			timeout = time.Duration(hook.TimeoutSec) * time.Second
+		}
+
+		start := time.Now()
+		resp, err := runCommand(hook.Argv, "", nil, timeout, maxHookOutputSize)
+		result := hookResult{
+			Name:         hook.Name,
+			ExitCode:     resp.ExitCode,
+			TimedOut:     resp.TimedOut,
+			Stdout:       resp.Stdout,
+			Stderr:       resp.Stderr,
+			DurationMsec: int64(time.Since(start) / time.Millisecond),
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+
+		if !result.Failed() {
+			_ = p.Logger.Infof("%s before %s", result, action)
+			continue
+		}
+
+		if hook.AbortOnFailure {
+			_ = p.Logger.Errorf("%s, aborting %s", result, action)
+			return results, false
+		}
+
+		_ = p.Logger.Warningf("%s, continuing with %s", result, action)
+	}
+
+	return results, true
+}
+
+// hookReport describes hook results in a line per hook.
+func hookReport(results []hookResult) string {
+	lines := make([]string, 0, len(results))
+	for _, result := range results {
+		lines = append(lines, result.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func validatePowerHooks(hooks []PowerHook) error {
+	for i, hook := range hooks {
+		if hook.Name == "" {
+			return errors.Errorf("no Name set for pre-power hook %d", i+1)
+		}
+		if len(hook.Argv) == 0 {
+			return errors.Errorf("no Argv set for pre-power hook '%s'", hook.Name)
+		}
+		for _, action := range hook.Actions {
+			if action != "poweroff" && action != "reboot" && !isSleepAction(action) {
+				return errors.Errorf("invalid action '%s' for pre-power hook '%s'", action, hook.Name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPowerHookRunsBefore(t *testing.T) {
+	tests := []struct {
+		actions []string
+		action  string
+		want    bool
+	}{
+		{nil, "poweroff", true},
+		{nil, "suspend", true},
+		{[]string{"poweroff", "reboot"}, "reboot", true},
+		{[]string{"poweroff", "reboot"}, "suspend", false},
+	}
+
+	for _, tt := range tests {
+		if got := (PowerHook{Actions: tt.actions}).runsBefore(tt.action); got != tt.want {
+			t.Errorf("actions %v, %s: got %v, want %v", tt.actions, tt.action, got, tt.want)
+		}
+	}
+}
+
+func TestRunPowerHooks(t *testing.T) {
+	tests := []struct {
+		name   string
+		hooks  []PowerHook
+		ok     bool
+		failed []bool
+	}{
+		{
+			name: "all succeed",
+			hooks: []PowerHook{
+				{Name: "sync", Argv: []string{"true"}},
+				{Name: "stop", Argv: []string{"echo", "stopped"}},
+			},
+			ok:     true,
+			failed: []bool{false, false},
+		},
+		{
+			name: "failure continues",
+			hooks: []PowerHook{
+				{Name: "fail", Argv: []string{"false"}},
+				{Name: "sync", Argv: []string{"true"}},
+			},
+			ok:     true,
+			failed: []bool{true, false},
+		},
+		{
+			name: "failure aborts",
+			hooks: []PowerHook{
+				{Name: "fail", Argv: []string{"false"}, AbortOnFailure: true},
+				{Name: "sync", Argv: []string{"true"}},
+			},
+			ok:     false,
+			failed: []bool{true},
+		},
+		{
+			name: "timeout aborts",
+			hooks: []PowerHook{
+				{Name: "slow", Argv: []string{"sleep", "5"}, TimeoutSec: 1, AbortOnFailure: true},
+			},
+			ok:     false,
+			failed: []bool{true},
+		},
+		{
+			name: "missing binary",
+			hooks: []PowerHook{
+				{Name: "missing", Argv: []string{"/nonexistent/hook"}},
+			},
+			ok:     true,
+			failed: []bool{true},
+		},
+		{
+			name: "other action skipped",
+			hooks: []PowerHook{
+				{Name: "fail", Argv: []string{"false"}, AbortOnFailure: true, Actions: []string{"suspend"}},
+			},
+			ok:     true,
+			failed: []bool{},
+		},
+	}
+
+	for _, tt := range tests {
+		p := newTestNode(t)
+		p.Config.Node.PrePowerHooks = tt.hooks
+
+		results, ok := p.runPowerHooks("poweroff")
+		if ok != tt.ok {
+			t.Errorf("%s: got ok %v, want %v", tt.name, ok, tt.ok)
+		}
+		if len(results) != len(tt.failed) {
+			t.Errorf("%s: got %d results, want %d", tt.name, len(results), len(tt.failed))
+			continue
+		}
+		for i, result := range results {
+			if result.Failed() != tt.failed[i] {
+				t.Errorf("%s: %s", tt.name, result)
+			}
+		}
+	}
+}
+
+func TestRunPowerHooksTimeout(t *testing.T) {
+	p := newTestNode(t)
+	p.Config.Node.PrePowerHooks = []PowerHook{{Name: "slow", Argv: []string{"sleep", "5"}, TimeoutSec: 1}}
+
+	results, _ := p.runPowerHooks("poweroff")
+	if len(results) != 1 || !results[0].TimedOut {
+		t.Fatalf("got %+v, want a timed out hook", results)
+	}
+}
+
+func TestValidatePowerHooks(t *testing.T) {
+	tests := []struct {
+		name  string
+		hook  PowerHook
+		valid bool
+	}{
+		{"valid", PowerHook{Name: "sync", Argv: []string{"sync"}, Actions: []string{"poweroff", "suspend"}}, true},
+		{"no name", PowerHook{Argv: []string{"sync"}}, false},
+		{"no argv", PowerHook{Name: "sync"}, false},
+		{"unknown action", PowerHook{Name: "sync", Argv: []string{"sync"}, Actions: []string{"halt"}}, false},
+	}
+
+	for _, tt := range tests {
+		if err := validatePowerHooks([]PowerHook{tt.hook}); (err == nil) != tt.valid {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
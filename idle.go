@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	utmpPath    = "/var/run/utmp"
+	procNetDev  = "/proc/net/dev"
+	procLoadavg = "/proc/loadavg"
+
+	// Size of a utmp record and the offsets of its fields with glibc on 64-bit
+	// little-endian Linux, like amd64 and arm64. Other C libraries and big-endian
+	// or 32-bit platforms use a different layout and are not supported.
+	utmpRecordSize  = 384
+	utmpUserOffset  = 44
+	utmpUserSize    = 32
+	utmpUserProcess = 7
+
+	defaultIdleCheckInterval = time.Minute
+)
+
+// IdleConfig makes a node power itself off after it has been idle for a while.
+// The node is busy while it has login sessions or maintenance locks, while any
+// of the thresholds is exceeded or while one of the processes runs.
+type IdleConfig struct {
+	// Idle time after which the node powers off. Idle poweroff is disabled when 0.
+	PoweroffAfterSec int
+
+	// Defaults to one minute.
+	CheckIntervalSec int
+
+	// One-minute load average above which the node is busy. Ignored when 0.
+	MaxLoadAverage float64
+
+	// Received plus sent bytes per second above which the node is busy.
+	// Ignored when 0.
+	MaxNetworkBytesPerSec int64
+
+	// Interfaces counted for MaxNetworkBytesPerSec. Defaults to all but "lo".
+	Interfaces []string
+
+	// Names of processes, as in /proc/<pid>/comm, that keep the node busy.
+	Processes []string
+}
+
+// nodeIdleStatus is reported in health responses of nodes with idle poweroff.
+type nodeIdleStatus struct {
+	IdleSec          int      `json:"IdleSec"`
+	PoweroffInSec    int      `json:"PoweroffInSec"`
+	BusyReasons      []string `json:"BusyReasons"`
+	KeepAwakeUntil   string   `json:"KeepAwakeUntil,omitempty"`
+	PoweroffAfterSec int      `json:"PoweroffAfterSec"`
+}
+
+// idleMonitor tracks for how long the node has been idle. The zero value is
+// ready to use.
+type idleMonitor struct {
+	mu             sync.Mutex
+	idleSince      time.Time
+	busyReasons    []string
+	keepAwakeUntil time.Time
+
+	lastNetBytes int64
+	lastNetCheck time.Time
+}
+
+// KeepAwake keeps the node busy until the given time. The zero time ends an
+// earlier override.
+func (m *idleMonitor) KeepAwake(until time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keepAwakeUntil = until
+	if !until.IsZero() {
+		m.idleSince = time.Time{}
+	}
+}
+
+// Status returns the idle status for a node that powers off after poweroffAfter.
+func (m *idleMonitor) Status(poweroffAfter time.Duration) *nodeIdleStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	status := &nodeIdleStatus{
+		PoweroffInSec:    int(poweroffAfter / time.Second),
+		BusyReasons:      append([]string{}, m.busyReasons...),
+		PoweroffAfterSec: int(poweroffAfter / time.Second),
+	}
+	if now.Before(m.keepAwakeUntil) {
+		status.KeepAwakeUntil = m.keepAwakeUntil.UTC().Format(time.RFC3339)
+	}
+	if !m.idleSince.IsZero() {
+		idle := now.Sub(m.idleSince)
+		status.IdleSec = int(idle / time.Second)
+		status.PoweroffInSec = int((poweroffAfter - idle) / time.Second)
+		if status.PoweroffInSec < 0 {
+			status.PoweroffInSec = 0
+		}
+	}
+
+	return status
+}
+
+// update records the busy reasons of a check and returns for how long the node
+// has been idle.
+func (m *idleMonitor) update(now time.Time, busyReasons []string) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Before(m.keepAwakeUntil) {
+		busyReasons = append(busyReasons, "kept awake by controller")
+	}
+
+	m.busyReasons = busyReasons
+	if len(busyReasons) > 0 {
+		m.idleSince = time.Time{}
+		return 0
+	}
+
+	if m.idleSince.IsZero() {
+		m.idleSince = now
+	}
+
+	return now.Sub(m.idleSince)
+}
+
+// reset restarts the idle time, like after an idle poweroff failed.
+func (m *idleMonitor) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.idleSince = time.Time{}
+}
+
+// networkRate returns the bytes per second since the previous call, or -1 on
+// the first call.
+func (m *idleMonitor) networkRate(now time.Time, total int64) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rate := int64(-1)
+	if !m.lastNetCheck.IsZero() && total >= m.lastNetBytes {
+		if elapsed := now.Sub(m.lastNetCheck).Seconds(); elapsed > 0 {
+			rate = int64(float64(total-m.lastNetBytes) / elapsed)
+		}
+	}
+	m.lastNetBytes = total
+	m.lastNetCheck = now
+
+	return rate
+}
+
+// runIdleMonitor checks whether the node is idle every CheckIntervalSec and
+// powers it off once it has been idle for PoweroffAfterSec.
+func (p *program) runIdleMonitor() error {
+	c := p.Config.Node.Idle
+	interval := defaultIdleCheckInterval
+	if c.CheckIntervalSec > 0 {
+		interval = time.Duration(c.CheckIntervalSec) * time.Second
+	}
+	poweroffAfter := time.Duration(c.PoweroffAfterSec) * time.Second
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.t.Dying():
+			return nil
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		idle := p.idle.update(now, p.busyReasons(now))
+		if idle < poweroffAfter {
+			continue
+		}
+
+		_ = p.Logger.Infof("node has been idle for %s, powering off", idle.Truncate(time.Second))
+		p.idle.reset()
+		if hooks, ok := p.runPowerHooks("poweroff"); !ok {
+			_ = p.Logger.Warningf("idle poweroff aborted:\n%s", hookReport(hooks))
+			continue
+		}
+		if err := p.ExecutePoweroff(); err != nil {
+			_ = p.Logger.Error(errors.Wrap(err, "cannot power off idle node"))
+		}
+	}
+}
+
+// busyReasons describes why the node is not idle. Failing checks are logged and
+// do not keep the node busy, except for reading the login sessions and the
+// maintenance locks: a node whose sessions or locks are unknown might be in use.
+func (p *program) busyReasons(now time.Time) []string {
+	c := p.Config.Node.Idle
+	reasons := make([]string, 0)
+
+	users, err := loginSessionUsers()
+	if err != nil {
+		_ = p.Logger.Warning(errors.Wrap(err, "cannot read login sessions"))
+		reasons = append(reasons, "login sessions unknown")
+	}
+	for _, user := range users {
+		reasons = append(reasons, "session of "+user)
+	}
+
+	locks, err := activeLocks()
+	if err != nil {
+		_ = p.Logger.Warning(errors.Wrap(err, "cannot read maintenance locks"))
+		reasons = append(reasons, "maintenance locks unknown")
+	}
+	for _, lock := range locks {
+		reasons = append(reasons, "lock "+lock.Name)
+	}
+
+	if c.MaxLoadAverage > 0 {
+		var load float64
+		load, err = loadAverage()
+		if err != nil {
+			_ = p.Logger.Warning(errors.Wrap(err, "cannot read load average"))
+		} else if load > c.MaxLoadAverage {
+			reasons = append(reasons, fmt.Sprintf("load average %.2f", load))
+		}
+	}
+
+	if c.MaxNetworkBytesPerSec > 0 {
+		var total int64
+		total, err = networkBytes(c.Interfaces)
+		if err != nil {
+			_ = p.Logger.Warning(errors.Wrap(err, "cannot read network statistics"))
+		} else if rate := p.idle.networkRate(now, total); rate > c.MaxNetworkBytesPerSec {
+			reasons = append(reasons, fmt.Sprintf("network traffic %d B/s", rate))
+		}
+	}
+
+	if len(c.Processes) > 0 {
+		var running []string
+		running, err = runningProcesses(c.Processes)
+		if err != nil {
+			_ = p.Logger.Warning(errors.Wrap(err, "cannot list processes"))
+		}
+		for _, name := range running {
+			reasons = append(reasons, "process "+name)
+		}
+	}
+
+	return reasons
+}
+
+// loginSessionUsers returns the users of the login sessions in utmp, like SSH
+// and console logins. Records of processes that no longer exist are ignored.
+func loginSessionUsers() ([]string, error) {
+	b, err := os.ReadFile(utmpPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "cannot read '%s'", utmpPath)
+	}
+
+	var users []string
+	users, err = parseUtmp(b, processExists)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse '%s'", utmpPath)
+	}
+
+	return users, nil
+}
+
+// parseUtmp returns the users of the user process records in b for which alive
+// reports that the process still exists.
+func parseUtmp(b []byte, alive func(pid uint32) bool) ([]string, error) {
+	// A size that is not a multiple of the record size means the layout is not
+	// the one this parser knows.
+	if len(b)%utmpRecordSize != 0 {
+		return nil, errors.Errorf("size %d is not a multiple of the record size %d", len(b), utmpRecordSize)
+	}
+
+	users := make([]string, 0)
+	for off := 0; off < len(b); off += utmpRecordSize {
+		record := b[off : off+utmpRecordSize]
+		if binary.LittleEndian.Uint16(record[0:2]) != utmpUserProcess {
+			continue
+		}
+
+		if !alive(binary.LittleEndian.Uint32(record[4:8])) {
+			continue
+		}
+
+		user := record[utmpUserOffset : utmpUserOffset+utmpUserSize]
+		if i := bytes.IndexByte(user, 0); i >= 0 {
+			user = user[:i]
+		}
+		users = append(users, string(user))
+	}
+
+	return users, nil
+}
+
+func processExists(pid uint32) bool {
+	_, err := os.Stat(filepath.Join("/proc", strconv.FormatUint(uint64(pid), 10)))
+	return err == nil
+}
+
+func loadAverage() (float64, error) {
+	b, err := os.ReadFile(procLoadavg)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot read '%s'", procLoadavg)
+	}
+
+	load, err := parseLoadavg(b)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot parse '%s'", procLoadavg)
+	}
+
+	return load, nil
+}
+
+// parseLoadavg returns the one-minute load average from /proc/loadavg contents.
+func parseLoadavg(b []byte) (float64, error) {
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, errors.New("no load average")
+	}
+
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// networkBytes returns the bytes received and sent by the interfaces, or by all
+// interfaces but the loopback interface if none are given.
+func networkBytes(interfaces []string) (int64, error) {
+	f, err := os.Open(procNetDev)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot open '%s'", procNetDev)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var total int64
+	total, err = parseNetDev(f, interfaces)
+	if err != nil {
+		return 0, errors.Wrapf(err, "cannot parse '%s'", procNetDev)
+	}
+
+	return total, nil
+}
+
+// parseNetDev sums the received and sent bytes in /proc/net/dev contents, like
+// networkBytes.
+func parseNetDev(r io.Reader, interfaces []string) (int64, error) {
+	var total int64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, ":")
+		if i < 0 {
+			// Header lines
+			continue
+		}
+
+		name := strings.TrimSpace(line[:i])
+		if len(interfaces) > 0 && !containsString(interfaces, name) || len(interfaces) == 0 && name == "lo" {
+			continue
+		}
+
+		// Received bytes are the first field, sent bytes the ninth.
+		fields := strings.Fields(line[i+1:])
+		if len(fields) < 9 {
+			return 0, errors.Errorf("unexpected line: %s", line)
+		}
+		for _, field := range []string{fields[0], fields[8]} {
+			n, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return 0, errors.Wrapf(err, "unexpected line: %s", line)
+			}
+			total += n
+		}
+	}
+
+	return total, errors.Wrap(scanner.Err(), "cannot read")
+}
+
+// runningProcesses returns which of the named processes are running.
+func runningProcesses(names []string) ([]string, error) {
+	paths, err := filepath.Glob("/proc/[0-9]*/comm")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list processes")
+	}
+
+	found := make(map[string]bool)
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			// The process has exited.
+			continue
+		}
+
+		name := strings.TrimSpace(string(b))
+		if containsString(names, name) {
+			found[name] = true
+		}
+	}
+
+	running := make([]string, 0, len(found))
+	for _, name := range names {
+		if found[name] {
+			running = append(running, name)
+		}
+	}
+
+	return running, nil
+}
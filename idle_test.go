@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// utmpRecord returns a utmp record of the given type for user and pid.
+func utmpRecord(recordType uint16, pid uint32, user string) []byte {
+	record := make([]byte, utmpRecordSize)
+	binary.LittleEndian.PutUint16(record[0:2], recordType)
+	binary.LittleEndian.PutUint32(record[4:8], pid)
+	copy(record[utmpUserOffset:utmpUserOffset+utmpUserSize], user)
+	return record
+}
+
+func TestParseUtmp(t *testing.T) {
+	const bootTime, loginProcess = 2, 6
+
+	var b []byte
+	b = append(b, utmpRecord(bootTime, 0, "reboot")...)
+	b = append(b, utmpRecord(utmpUserProcess, 100, "alice")...)
+	b = append(b, utmpRecord(loginProcess, 101, "LOGIN")...)
+	b = append(b, utmpRecord(utmpUserProcess, 102, "bob")...)
+	b = append(b, utmpRecord(utmpUserProcess, 103, strings.Repeat("x", utmpUserSize))...)
+
+	alive := func(pid uint32) bool {
+		return pid != 102
+	}
+	users, err := parseUtmp(b, alive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(users), fmt.Sprint([]string{"alice", strings.Repeat("x", utmpUserSize)}); got != want {
+		t.Fatalf("got users %s, want %s", got, want)
+	}
+
+	users, err = parseUtmp(nil, alive)
+	if err != nil || len(users) != 0 {
+		t.Fatalf("empty utmp: got users %v and error %v, want none", users, err)
+	}
+}
+
+func TestParseUtmpUnknownLayout(t *testing.T) {
+	b := utmpRecord(utmpUserProcess, 100, "alice")
+	for _, size := range []int{utmpRecordSize - 1, utmpRecordSize + 1, 2*utmpRecordSize - 12} {
+		record := make([]byte, size)
+		copy(record, b)
+		if _, err := parseUtmp(record, func(uint32) bool { return true }); err == nil {
+			t.Errorf("size %d: got no error", size)
+		}
+	}
+}
+
+func TestParseLoadavg(t *testing.T) {
+	load, err := parseLoadavg([]byte("0.52 0.58 0.59 2/1342 49781\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if load != 0.52 {
+		t.Fatalf("got load %v, want 0.52", load)
+	}
+
+	for _, s := range []string{"", "\n", "high 0.58 0.59 2/1342 49781\n"} {
+		if _, err = parseLoadavg([]byte(s)); err == nil {
+			t.Errorf("%q: got no error", s)
+		}
+	}
+}
+
+const testNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+  eth0: 2000      20    0    0    0     0          0         0      300       3    0    0    0     0       0          0
+ wlan0:40000     400    0    0    0     0          0         0     5000      50    0    0    0     0       0          0
+`
+
+func TestParseNetDev(t *testing.T) {
+	tests := []struct {
+		interfaces []string
+		want       int64
+	}{
+		{nil, 2000 + 300 + 40000 + 5000},
+		{[]string{"eth0"}, 2000 + 300},
+		{[]string{"lo", "wlan0"}, 1000 + 1000 + 40000 + 5000},
+		{[]string{"eth1"}, 0},
+	}
+
+	for _, test := range tests {
+		total, err := parseNetDev(strings.NewReader(testNetDev), test.interfaces)
+		if err != nil {
+			t.Fatalf("%v: %v", test.interfaces, err)
+		}
+		if total != test.want {
+			t.Errorf("%v: got %d bytes, want %d", test.interfaces, total, test.want)
+		}
+	}
+
+	for _, s := range []string{"eth0: 1 2 3\n", "eth0: x 0 0 0 0 0 0 0 1 0 0 0 0 0 0 0\n"} {
+		if _, err := parseNetDev(strings.NewReader(s), nil); err == nil {
+			t.Errorf("%q: got no error", s)
+		}
+	}
+}
+
+func TestIdleMonitor(t *testing.T) {
+	var m idleMonitor
+	now := time.Date(2026, 4, 15, 10, 0, 0, 0, time.UTC)
+
+	if idle := m.update(now, nil); idle != 0 {
+		t.Fatalf("first idle check: got %s, want 0", idle)
+	}
+	if idle := m.update(now.Add(time.Minute), nil); idle != time.Minute {
+		t.Fatalf("got idle %s, want 1m", idle)
+	}
+	if idle := m.update(now.Add(2*time.Minute), []string{"session of alice"}); idle != 0 {
+		t.Fatalf("busy: got idle %s, want 0", idle)
+	}
+	if idle := m.update(now.Add(3*time.Minute), nil); idle != 0 {
+		t.Fatalf("idle again: got %s, want 0", idle)
+	}
+
+	m.KeepAwake(now.Add(10 * time.Minute))
+	if idle := m.update(now.Add(5*time.Minute), nil); idle != 0 {
+		t.Fatalf("kept awake: got idle %s, want 0", idle)
+	}
+	if idle := m.update(now.Add(11*time.Minute), nil); idle != 0 {
+		t.Fatalf("after keep awake: got idle %s, want 0", idle)
+	}
+	if idle := m.update(now.Add(12*time.Minute), nil); idle != time.Minute {
+		t.Fatalf("after keep awake: got idle %s, want 1m", idle)
+	}
+}
+
+func TestIdleMonitorNetworkRate(t *testing.T) {
+	var m idleMonitor
+	now := time.Date(2026, 4, 15, 10, 0, 0, 0, time.UTC)
+
+	if rate := m.networkRate(now, 1000); rate != -1 {
+		t.Fatalf("first check: got rate %d, want -1", rate)
+	}
+	if rate := m.networkRate(now.Add(10*time.Second), 6000); rate != 500 {
+		t.Fatalf("got rate %d, want 500", rate)
+	}
+	// Counters reset, like when an interface is recreated.
+	if rate := m.networkRate(now.Add(20*time.Second), 100); rate != -1 {
+		t.Fatalf("after counter reset: got rate %d, want -1", rate)
+	}
+}
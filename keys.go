@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto"
+	"encoding/pem"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// PEM header of an authorized key that restricts which actions the key may
+	// perform, e.g. "Scopes: health, poweroff". Keys without the header may
+	// perform every action, and "*" grants every action explicitly.
+	scopesPEMHeader = "Scopes"
+
+	// PEM header with the RFC 3339 time after which an authorized key is retired.
+	// Set on the old key when a controller rotates its self key.
+	expiresPEMHeader = "Expires"
+)
+
+type authorizedKey struct {
+	// File the key was loaded from.
+	Path string
+	Key  crypto.PublicKey
+
+	// Action types the key may perform. Nil means the key is not restricted.
+	Scopes map[string]bool
+
+	// Time after which the key is no longer accepted. Zero means never.
+	Expires time.Time
+}
+
+func (k authorizedKey) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && !now.Before(k.Expires)
+}
+
+// HasScope reports whether the key may perform actions of the given type.
+func (k authorizedKey) HasScope(scope string) bool {
+	if k.Scopes == nil {
+		return true
+	}
+
+	return k.Scopes["*"] || k.Scopes[scope]
+}
+
+// HasAnyScope reports whether the key has at least one of the scopes.
+func (k authorizedKey) HasAnyScope(scopes []string) bool {
+	for _, scope := range scopes {
+		if k.HasScope(scope) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keyring holds the authorized keys by key ID. Keys can be added and retired
+// while the node is running.
+type keyring struct {
+	mu   sync.RWMutex
+	keys map[string]authorizedKey
+}
+
+func newKeyring(keys map[string]authorizedKey) *keyring {
+	return &keyring{keys: keys}
+}
+
+// Get returns the key with the given ID. Expired keys are retired on access.
+func (k *keyring) Get(keyID string) (authorizedKey, bool) {
+	k.mu.RLock()
+	key, ok := k.keys[keyID]
+	k.mu.RUnlock()
+
+	if ok && key.Expired(time.Now()) {
+		_ = k.Retire(keyID)
+		return authorizedKey{}, false
+	}
+
+	return key, ok
+}
+
+// Rotate authorizes newKey with the scopes of the key with ID oldKeyID, and lets
+// the old key expire after gracePeriod. Rotating to a key that is already
+// authorized only sets the expiry of the old key.
+func (k *keyring) Rotate(oldKeyID string, newKey crypto.PublicKey, gracePeriod time.Duration) (authorizedKey, error) {
+	newKeyID, err := keyFingerprint(newKey)
+	if err != nil {
+		return authorizedKey{}, errors.Wrap(err, "cannot determine new key ID")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	oldKey, ok := k.keys[oldKeyID]
+	if !ok {
+		return authorizedKey{}, errors.Errorf("key '%s' is not authorized", oldKeyID)
+	}
+	if newKeyID == oldKeyID {
+		return authorizedKey{}, errors.New("new key is the same as the old key")
+	}
+
+	added, ok := k.keys[newKeyID]
+	if !ok {
+		var scopes []string
+		if oldKey.Scopes != nil {
+			scopes = make([]string, 0, len(oldKey.Scopes))
+			for scope := range oldKey.Scopes {
+				scopes = append(scopes, scope)
+			}
+			sort.Strings(scopes)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(oldKey.Path), ".pub") + "-" + time.Now().Format("20060102150405")
+		var path string
+		path, err = writeAuthorizedKey(name, newKey, scopes)
+		if err != nil {
+			return authorizedKey{}, errors.Wrap(err, "cannot write new key")
+		}
+
+		added = authorizedKey{Path: path, Key: newKey, Scopes: oldKey.Scopes}
+		k.keys[newKeyID] = added
+	}
+
+	expires := time.Now().Add(gracePeriod)
+	if oldKey.Expires.IsZero() || expires.Before(oldKey.Expires) {
+		if err = setAuthorizedKeyExpiry(oldKey.Path, expires); err != nil {
+			return authorizedKey{}, errors.Wrap(err, "cannot set expiry of old key")
+		}
+
+		oldKey.Expires = expires
+		k.keys[oldKeyID] = oldKey
+	}
+
+	return added, nil
+}
+
+// Retire forgets the key with the given ID and removes its file.
+func (k *keyring) Retire(keyID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil
+	}
+
+	delete(k.keys, keyID)
+	if err := os.Remove(key.Path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "cannot remove key file '%s'", key.Path)
+	}
+
+	return nil
+}
+
+// loadAuthorizedKeys reads all keys in the authorized keys directory. Keys that
+// have expired are removed instead.
+func loadAuthorizedKeys() (*keyring, error) {
+	keys := make(map[string]authorizedKey)
+	now := time.Now()
+	err := filepath.Walk(authorizedKeysDirName, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		var key authorizedKey
+		key, err = loadAuthorizedKey(path)
+		if err != nil {
+			return errors.Wrapf(err, "cannot read key at '%s'", path)
+		}
+
+		if key.Expired(now) {
+			if err = os.Remove(path); err != nil {
+				return errors.Wrapf(err, "cannot remove expired key at '%s'", path)
+			}
+
+			return nil
+		}
+
+		var keyID string
+		keyID, err = keyFingerprint(key.Key)
+		if err != nil {
+			return errors.Wrapf(err, "cannot determine ID of key at '%s'", path)
+		}
+
+		if existing, ok := keys[keyID]; ok {
+			return errors.Errorf("key at '%s' is the same as key at '%s'", path, existing.Path)
+		}
+
+		keys[keyID] = key
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot walk directory '%s'", authorizedKeysDirName)
+	}
+
+	return newKeyring(keys), nil
+}
+
+func loadAuthorizedKey(path string) (authorizedKey, error) {
+	block, err := readPublicKeyBlock(path)
+	if err != nil {
+		return authorizedKey{}, err
+	}
+
+	var publicKey crypto.PublicKey
+	publicKey, err = parsePublicKeyBlock(block)
+	if err != nil {
+		return authorizedKey{}, errors.Wrapf(err, "cannot parse public key file '%s'", path)
+	}
+
+	key := authorizedKey{Path: path, Key: publicKey}
+	if header, ok := block.Headers[scopesPEMHeader]; ok {
+		key.Scopes = make(map[string]bool)
+		for _, scope := range strings.Split(header, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				key.Scopes[scope] = true
+			}
+		}
+	}
+
+	if header, ok := block.Headers[expiresPEMHeader]; ok {
+		key.Expires, err = time.Parse(time.RFC3339, header)
+		if err != nil {
+			return authorizedKey{}, errors.Wrapf(err, "cannot parse %s header", expiresPEMHeader)
+		}
+	}
+
+	return key, nil
+}
+
+// writeAuthorizedKey stores publicKey in the authorized keys directory under a
+// file name derived from name. Nil scopes leave the key unrestricted.
+func writeAuthorizedKey(name string, publicKey crypto.PublicKey, scopes []string) (string, error) {
+	block, err := encodePublicKeyBlock(publicKey)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot encode public key")
+	}
+
+	if scopes != nil {
+		block.Headers = map[string]string{scopesPEMHeader: strings.Join(scopes, ", ")}
+	}
+
+	path := filepath.Join(authorizedKeysDirName, authorizedKeyFileName(name))
+	if _, err = os.Stat(path); err == nil {
+		return "", errors.Errorf("file '%s' already exists", path)
+	}
+
+	if err = writePEMFile(path, block); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// setAuthorizedKeyExpiry rewrites the key file at path with an Expires header,
+// keeping its other headers.
+func setAuthorizedKeyExpiry(path string, expires time.Time) error {
+	block, err := readPublicKeyBlock(path)
+	if err != nil {
+		return err
+	}
+
+	if block.Headers == nil {
+		block.Headers = make(map[string]string)
+	}
+	block.Headers[expiresPEMHeader] = expires.UTC().Format(time.RFC3339)
+
+	return writePEMFile(path, block)
+}
+
+func authorizedKeyFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-') {
+			return r
+		}
+
+		return '_'
+	}, name)
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		name = "key"
+	}
+
+	return name + ".pub"
+}
+
+// parsePublicKeyPEM parses a PEM encoded public key and returns it with its key ID.
+func parsePublicKeyPEM(s string) (crypto.PublicKey, string, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, "", errors.New("cannot decode PEM")
+	}
+
+	publicKey, err := parsePublicKeyBlock(block)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var keyID string
+	keyID, err = keyFingerprint(publicKey)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "cannot determine key ID")
+	}
+
+	return publicKey, keyID, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuthorizedKeyHasScope(t *testing.T) {
+	tests := []struct {
+		scopes map[string]bool
+		scope  string
+		want   bool
+	}{
+		{nil, "poweroff", true},
+		{map[string]bool{}, "poweroff", false},
+		{map[string]bool{"health": true}, "health", true},
+		{map[string]bool{"health": true}, "poweroff", false},
+		{map[string]bool{"*": true}, "poweroff", true},
+	}
+
+	for _, test := range tests {
+		key := authorizedKey{Scopes: test.scopes}
+		if got := key.HasScope(test.scope); got != test.want {
+			t.Errorf("scopes %v: HasScope(%q) = %v, want %v", test.scopes, test.scope, got, test.want)
+		}
+	}
+}
+
+func TestLoadAuthorizedKeyScopes(t *testing.T) {
+	tests := []struct {
+		headers map[string]string
+		want    map[string]bool
+	}{
+		{nil, nil},
+		{map[string]string{scopesPEMHeader: "health"}, map[string]bool{"health": true}},
+		{map[string]string{scopesPEMHeader: " health, poweroff ,"}, map[string]bool{"health": true, "poweroff": true}},
+		{map[string]string{scopesPEMHeader: ""}, map[string]bool{}},
+	}
+
+	key := newTestKey(t)
+	path := filepath.Join(t.TempDir(), "key.pub")
+	for _, test := range tests {
+		block, err := encodePublicKeyBlock(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.Headers = test.headers
+		if err = writePEMFile(path, block); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := loadAuthorizedKey(path)
+		if err != nil {
+			t.Fatalf("headers %v: %v", test.headers, err)
+		}
+		if !reflect.DeepEqual(got.Scopes, test.want) {
+			t.Errorf("headers %v: got scopes %v, want %v", test.headers, got.Scopes, test.want)
+		}
+		if !publicKeysEqual(got.Key, key.Public()) {
+			t.Errorf("headers %v: loaded key differs", test.headers)
+		}
+	}
+}
+
+func TestKeyringRotate(t *testing.T) {
+	chdirTemp(t)
+	if err := os.Mkdir(authorizedKeysDirName, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	oldKey := newTestKey(t)
+	oldKeyID := testKeyID(t, oldKey.Public())
+	if _, err := writeAuthorizedKey("controller", oldKey.Public(), []string{"health"}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := loadAuthorizedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = keys.Rotate(oldKeyID, oldKey.Public(), time.Hour); err == nil {
+		t.Error("rotate to same key: expected error")
+	}
+	if _, err = keys.Rotate("SHA256:unknown", newTestKey(t).Public(), time.Hour); err == nil {
+		t.Error("rotate unknown key: expected error")
+	}
+
+	newKey := newTestKey(t)
+	newKeyID := testKeyID(t, newKey.Public())
+	if _, err = keys.Rotate(oldKeyID, newKey.Public(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys must survive a restart of the node.
+	keys, err = loadAuthorizedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, ok := keys.Get(newKeyID)
+	if !ok {
+		t.Fatal("new key is not authorized")
+	}
+	if !reflect.DeepEqual(added.Scopes, map[string]bool{"health": true}) {
+		t.Errorf("new key has scopes %v, want scopes of old key", added.Scopes)
+	}
+	if !added.Expires.IsZero() {
+		t.Errorf("new key expires at %s", added.Expires)
+	}
+
+	old, ok := keys.Get(oldKeyID)
+	if !ok {
+		t.Fatal("old key retired during grace period")
+	}
+	if d := time.Until(old.Expires); d <= 0 || d > time.Hour {
+		t.Errorf("old key expires in %s, want within an hour", d)
+	}
+
+	// Rotating again must not extend the grace period of the old key.
+	if _, err = keys.Rotate(oldKeyID, newKey.Public(), 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := keys.Get(oldKeyID); !again.Expires.Equal(old.Expires) {
+		t.Errorf("old key expiry moved from %s to %s", old.Expires, again.Expires)
+	}
+}
+
+func TestKeyringExpiry(t *testing.T) {
+	chdirTemp(t)
+	if err := os.Mkdir(authorizedKeysDirName, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	oldKey := newTestKey(t)
+	oldKeyID := testKeyID(t, oldKey.Public())
+	path, err := writeAuthorizedKey("controller", oldKey.Public(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := loadAuthorizedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = keys.Rotate(oldKeyID, newTestKey(t).Public(), -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := keys.Get(oldKeyID); ok {
+		t.Error("expired key is still authorized")
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expired key file still exists: %v", err)
+	}
+
+	// Expired key files are removed when the node starts, too.
+	if _, err = writeAuthorizedKey("controller", oldKey.Public(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = setAuthorizedKeyExpiry(path, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = loadAuthorizedKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys.Get(oldKeyID); ok {
+		t.Error("expired key is authorized after loading")
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expired key file still exists after loading: %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	locksDirName = "locks"
+
+	// Scope an authorized key needs to force power actions despite locks.
+	forceScope = "force"
+)
+
+var lockNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// maintenanceLock makes the node refuse power actions that are not forced, like
+// during a backup or RAID rebuild. Locks are files in the locks directory, so
+// local scripts can take them with the CLI while the service runs.
+type maintenanceLock struct {
+	Name      string `json:"Name"`
+	Owner     string `json:"Owner"`
+	Reason    string `json:"Reason"`
+	CreatedAt string `json:"CreatedAt"`
+
+	// Empty for locks that are held until released.
+	ExpiresAt string `json:"ExpiresAt,omitempty"`
+}
+
+func (l maintenanceLock) expired(now time.Time) bool {
+	if l.ExpiresAt == "" {
+		return false
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, l.ExpiresAt)
+	return err == nil && !now.Before(expiresAt)
+}
+
+func (l maintenanceLock) String() string {
+	s := fmt.Sprintf("'%s' held by %s", l.Name, l.Owner)
+	if l.Reason != "" {
+		s += ": " + l.Reason
+	}
+	if l.ExpiresAt != "" {
+		s += " (until " + l.ExpiresAt + ")"
+	}
+
+	return s
+}
+
+func newMaintenanceLock(name string, owner string, reason string, ttl time.Duration) (maintenanceLock, error) {
+	if !lockNamePattern.MatchString(name) {
+		return maintenanceLock{}, errors.Errorf("invalid lock name '%s'", name)
+	}
+	if ttl < 0 {
+		return maintenanceLock{}, errors.New("lock TTL must not be negative")
+	}
+
+	now := time.Now()
+	lock := maintenanceLock{
+		Name:      name,
+		Owner:     owner,
+		Reason:    reason,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+	}
+	if ttl > 0 {
+		lock.ExpiresAt = now.Add(ttl).UTC().Format(time.RFC3339)
+	}
+
+	return lock, nil
+}
+
+func lockFilePath(name string) string {
+	return filepath.Join(locksDirName, name+".json")
+}
+
+// acquireLock writes the lock, replacing an earlier lock with the same name.
+func acquireLock(lock maintenanceLock) error {
+	if err := os.MkdirAll(locksDirName, 0700); err != nil {
+		return errors.Wrapf(err, "cannot create directory '%s'", locksDirName)
+	}
+
+	b, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "cannot encode lock JSON")
+	}
+
+	// Write and rename, so readers never see a partial lock.
+	path := lockFilePath(lock.Name)
+	tmpPath := path + ".tmp"
+	if err = os.WriteFile(tmpPath, b, 0600); err != nil {
+		return errors.Wrapf(err, "cannot write file '%s'", tmpPath)
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return errors.Wrapf(err, "cannot rename '%s' to '%s'", tmpPath, path)
+	}
+
+	return nil
+}
+
+// releaseLock removes the named lock and reports whether it was held.
+func releaseLock(name string) (bool, error) {
+	if !lockNamePattern.MatchString(name) {
+		return false, errors.Errorf("invalid lock name '%s'", name)
+	}
+
+	path := lockFilePath(name)
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "cannot remove file '%s'", path)
+	}
+
+	return true, nil
+}
+
+// activeLocks returns the locks that have not expired, sorted by name. Expired
+// lock files are removed.
+func activeLocks() ([]maintenanceLock, error) {
+	entries, err := os.ReadDir(locksDirName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []maintenanceLock{}, nil
+		}
+		return nil, errors.Wrapf(err, "cannot list directory '%s'", locksDirName)
+	}
+
+	now := time.Now()
+	locks := make([]maintenanceLock, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		path := filepath.Join(locksDirName, entry.Name())
+		var b []byte
+		b, err = os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Released meanwhile
+				continue
+			}
+			return nil, errors.Wrapf(err, "cannot read file '%s'", path)
+		}
+
+		var lock maintenanceLock
+		if err = json.Unmarshal(b, &lock); err != nil {
+			return nil, errors.Wrapf(err, "cannot decode lock file '%s'", path)
+		}
+
+		if lock.expired(now) {
+			_ = os.Remove(path)
+			continue
+		}
+		locks = append(locks, lock)
+	}
+
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].Name < locks[j].Name
+	})
+
+	return locks, nil
+}
+
+// lockReport describes the locks in one line.
+func lockReport(locks []maintenanceLock) string {
+	descriptions := make([]string, 0, len(locks))
+	for _, lock := range locks {
+		descriptions = append(descriptions, lock.String())
+	}
+
+	return strings.Join(descriptions, "; ")
+}
+
+// runLockCommand takes a lock for local scripts: --lock <name> [ttl] [reason].
+// The TTL is a duration like "2h"; "0" or no TTL holds the lock until released.
+func runLockCommand(args []string) error {
+	name := args[0]
+	var ttl time.Duration
+	if len(args) > 1 {
+		var err error
+		ttl, err = time.ParseDuration(args[1])
+		if err != nil {
+			return errors.Wrapf(err, "invalid TTL '%s'", args[1])
+		}
+	}
+
+	owner := "local"
+	if u, err := user.Current(); err == nil {
+		owner = "local user " + u.Username
+	}
+
+	var reason string
+	if len(args) > 2 {
+		reason = strings.Join(args[2:], " ")
+	}
+
+	lock, err := newMaintenanceLock(name, owner, reason, ttl)
+	if err != nil {
+		return err
+	}
+
+	if err = acquireLock(lock); err != nil {
+		return err
+	}
+
+	fmt.Printf("Acquired lock %s\n", lock)
+	return nil
+}
+
+func runUnlockCommand(name string) error {
+	released, err := releaseLock(name)
+	if err != nil {
+		return err
+	}
+
+	if !released {
+		return errors.Errorf("lock '%s' is not held", name)
+	}
+
+	fmt.Printf("Released lock '%s'\n", name)
+	return nil
+}
+
+func printLocks() error {
+	locks, err := activeLocks()
+	if err != nil {
+		return err
+	}
+
+	if len(locks) == 0 {
+		fmt.Println("No locks held")
+	}
+	for _, lock := range locks {
+		fmt.Println(lock)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func mustAcquireLock(t *testing.T, name string, ttl time.Duration) {
+	t.Helper()
+
+	lock, err := newMaintenanceLock(name, "test", "running", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = acquireLock(lock); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func activeLockNames(t *testing.T) []string {
+	t.Helper()
+
+	locks, err := activeLocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(locks))
+	for _, lock := range locks {
+		names = append(names, lock.Name)
+	}
+
+	return names
+}
+
+func TestAcquireReleaseLock(t *testing.T) {
+	chdirTemp(t)
+
+	if got := activeLockNames(t); len(got) != 0 {
+		t.Fatalf("without locks directory: got locks %v, want none", got)
+	}
+
+	mustAcquireLock(t, "scrub", 0)
+	mustAcquireLock(t, "backup", time.Hour)
+	mustAcquireLock(t, "backup", 0)
+	if got := fmt.Sprint(activeLockNames(t)); got != "[backup scrub]" {
+		t.Fatalf("got locks %s, want [backup scrub]", got)
+	}
+
+	released, err := releaseLock("backup")
+	if err != nil || !released {
+		t.Fatalf("got %v and error %v, want the lock released", released, err)
+	}
+	released, err = releaseLock("backup")
+	if err != nil || released {
+		t.Fatalf("second release: got %v and error %v, want nothing released", released, err)
+	}
+	if got := fmt.Sprint(activeLockNames(t)); got != "[scrub]" {
+		t.Fatalf("got locks %s, want [scrub]", got)
+	}
+}
+
+func TestNewMaintenanceLockInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+	}{
+		{"", 0},
+		{"../backup", 0},
+		{"back up", 0},
+		{"backup", -time.Minute},
+	}
+
+	for _, test := range tests {
+		if _, err := newMaintenanceLock(test.name, "test", "", test.ttl); err == nil {
+			t.Errorf("%q, TTL %s: got no error", test.name, test.ttl)
+		}
+	}
+}
+
+func TestActiveLocksExpired(t *testing.T) {
+	chdirTemp(t)
+
+	mustAcquireLock(t, "backup", time.Hour)
+	expired := maintenanceLock{Name: "scrub", Owner: "test", ExpiresAt: time.Now().Add(-time.Minute).Format(time.RFC3339)}
+	if err := acquireLock(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fmt.Sprint(activeLockNames(t)); got != "[backup]" {
+		t.Fatalf("got locks %s, want [backup]", got)
+	}
+	if _, err := os.Stat(lockFilePath("scrub")); !os.IsNotExist(err) {
+		t.Fatalf("expired lock file was not removed: %v", err)
+	}
+}
+
+func TestNodePowerHandlersLocked(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  map[string]bool
+		action  actionInterface
+		want    int
+		actions string
+	}{
+		{"unforced", nil, &nodePoweroffAction{}, http.StatusLocked, "[]"},
+		{"forced", nil, &nodeRebootAction{Force: true}, http.StatusOK, "[reboot]"},
+		{"forced with force scope", map[string]bool{"reboot": true, "force": true}, &nodeRebootAction{Force: true}, http.StatusOK, "[reboot]"},
+		{"forced with all scopes", map[string]bool{"*": true}, &nodeRebootAction{Force: true}, http.StatusOK, "[reboot]"},
+		{"forced without force scope", map[string]bool{"reboot": true}, &nodeRebootAction{Force: true}, http.StatusForbidden, "[]"},
+	}
+
+	for _, test := range tests {
+		chdirTemp(t)
+		mustAcquireLock(t, "backup", 0)
+
+		p, executor := newDryRunNode(t)
+		key := p.Config.authorizedKeys.keys[p.Config.selfKeyID]
+		key.Scopes = test.scopes
+		p.Config.authorizedKeys.keys[p.Config.selfKeyID] = key
+
+		handler, endpoint := p.nodeExecutePoweroffHandler, "/node/execute/poweroff"
+		if _, ok := test.action.(*nodeRebootAction); ok {
+			handler, endpoint = p.nodeExecuteRebootHandler, "/node/execute/reboot"
+		}
+
+		rec := serveNodeRequest(t, p, handler, endpoint, test.action)
+		if rec.Code != test.want {
+			t.Errorf("%s: got status %d (%s), want %d", test.name, rec.Code, rec.Body.String(), test.want)
+		}
+		if got := fmt.Sprint(recordedActions(executor)); got != test.actions {
+			t.Errorf("%s: got actions %s, want %s", test.name, got, test.actions)
+		}
+	}
+}
+
+func TestBusyReasonsLocks(t *testing.T) {
+	chdirTemp(t)
+	p := newTestNode(t)
+
+	mustAcquireLock(t, "backup", 0)
+	if reasons := p.busyReasons(time.Now()); !containsString(reasons, "lock backup") {
+		t.Errorf("got reasons %v, want the lock", reasons)
+	}
+
+	// A locks directory that cannot be read might hide locks.
+	if err := os.RemoveAll(locksDirName); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(locksDirName, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if reasons := p.busyReasons(time.Now()); !containsString(reasons, "maintenance locks unknown") {
+		t.Errorf("got reasons %v, want the locks unknown", reasons)
+	}
+}
@@ -1,18 +1,59 @@
 package main
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
+// scopedAction is implemented by actions that are authorized by other scopes
+// than their action type. A key needs at least one of the scopes.
+type scopedAction interface {
+	RequiredScopes() []string
+}
+
+// forcibleAction is implemented by power actions that can ignore maintenance
+// locks. Forcing them needs forceScope on top of the scope of the action.
+type forcibleAction interface {
+	Forced() bool
+}
+
 type actionInterface interface {
+	ActionType() string
 	SetCurrentTime(time.Time)
 	ParseCurrentTime() (time.Time, error)
+	SetRequestID(string)
+	GetRequestID() string
+}
+
+// nodeRequestEnvelope is the signed body of every node request. It binds the
+// action to the endpoint, method and host it was meant for, so that a captured
+// request cannot be reused as another action or sent to another node.
+type nodeRequestEnvelope struct {
+	ActionType string          `json:"ActionType"`
+	Method     string          `json:"Method"`
+	Path       string          `json:"Path"`
+	Host       string          `json:"Host"`
+	Action     json.RawMessage `json:"Action"`
+}
+
+// nodeResponseEnvelope is what nodes sign for each response. The body itself is
+// sent as is; the envelope ties it to the request it answers.
+type nodeResponseEnvelope struct {
+	RequestID   string `json:"RequestID"`
+	Path        string `json:"Path"`
+	StatusCode  int    `json:"StatusCode"`
+	CurrentTime string `json:"CurrentTime"`
+	Body        []byte `json:"Body"`
 }
 
 type baseAction struct {
 	CurrentTime string `json:"CurrentTime"`
+
+	// Random ID that makes every request unique. Nodes reject IDs they have
+	// already seen.
+	RequestID string `json:"RequestID"`
 }
 
 func (a *baseAction) SetCurrentTime(t time.Time) {
@@ -27,16 +68,181 @@ func (a baseAction) ParseCurrentTime() (time.Time, error) {
 	return time.Parse(time.RFC3339, a.CurrentTime)
 }
 
+func (a *baseAction) SetRequestID(id string) {
+	a.RequestID = id
+}
+
+func (a baseAction) GetRequestID() string {
+	return a.RequestID
+}
+
 type nodePoweroffAction struct {
 	baseAction
 	Async             bool `json:"Async"`
 	PoweroffDelayMsec int  `json:"PoweroffDelayMsec"`
+
+	// Execute the action even if the node holds maintenance locks.
+	Force bool `json:"Force"`
+}
+
+func (a nodePoweroffAction) ActionType() string {
+	return "poweroff"
+}
+
+func (a nodePoweroffAction) Forced() bool {
+	return a.Force
+}
+
+type nodeRebootAction struct {
+	baseAction
+	Async           bool `json:"Async"`
+	RebootDelayMsec int  `json:"RebootDelayMsec"`
+	Force           bool `json:"Force"`
+}
+
+func (a nodeRebootAction) ActionType() string {
+	return "reboot"
+}
+
+func (a nodeRebootAction) Forced() bool {
+	return a.Force
+}
+
+type nodeSleepAction struct {
+	baseAction
+	Async          bool `json:"Async"`
+	SleepDelayMsec int  `json:"SleepDelayMsec"`
+	Force          bool `json:"Force"`
+
+	// One of sleepActions. It is part of the endpoint path rather than the body.
+	Action string `json:"-"`
+}
+
+func (a nodeSleepAction) ActionType() string {
+	return a.Action
+}
+
+func (a nodeSleepAction) Forced() bool {
+	return a.Force
+}
+
+type nodeCancelAction struct {
+	baseAction
+
+	// ID of the pending power action to cancel. Empty cancels all of them.
+	ID string `json:"ID"`
+}
+
+func (a nodeCancelAction) ActionType() string {
+	return "cancel"
+}
+
+type nodeCommandAction struct {
+	baseAction
+
+	// Name and scopes of the command are determined by the endpoint path.
+	Name   string   `json:"-"`
+	Scopes []string `json:"-"`
+}
+
+func (a nodeCommandAction) ActionType() string {
+	return commandActionType(a.Name)
+}
+
+func (a nodeCommandAction) RequiredScopes() []string {
+	return a.Scopes
+}
+
+type nodeKeepAwakeAction struct {
+	baseAction
+
+	// How long the node must not power off for being idle. Zero ends an earlier
+	// keep awake.
+	DurationSec int `json:"DurationSec"`
+}
+
+func (a nodeKeepAwakeAction) ActionType() string {
+	return "keep-awake"
+}
+
+type nodeLockAction struct {
+	baseAction
+	Name   string `json:"Name"`
+	Owner  string `json:"Owner"`
+	Reason string `json:"Reason"`
+
+	// Zero holds the lock until it is released.
+	TTLSec int `json:"TTLSec"`
+}
+
+func (a nodeLockAction) ActionType() string {
+	return "lock"
+}
+
+type nodeUnlockAction struct {
+	baseAction
+	Name string `json:"Name"`
+}
+
+func (a nodeUnlockAction) ActionType() string {
+	return "unlock"
 }
 
 type nodeHealthAction struct {
 	baseAction
 }
 
+func (a nodeHealthAction) ActionType() string {
+	return "health"
+}
+
+type nodeRotateKeyAction struct {
+	baseAction
+
+	// PEM encoded public key that replaces the key that signs this action.
+	NewPublicKey   string `json:"NewPublicKey"`
+	GracePeriodSec int    `json:"GracePeriodSec"`
+}
+
+func (a nodeRotateKeyAction) ActionType() string {
+	return "rotate-key"
+}
+
+type nodeRevocationsAction struct {
+	baseAction
+	List signedRevocationList `json:"List"`
+}
+
+func (a nodeRevocationsAction) ActionType() string {
+	return revokeScope
+}
+
 type nodeHealthResponse struct {
 	Status string `json:"Status"`
+
+	// Version of the newest revocation list the node has received per signer
+	// key ID.
+	RevocationVersions map[string]int `json:"RevocationVersions"`
+
+	// Sleep actions the node supports.
+	SleepStates []string `json:"SleepStates"`
+
+	// Power actions that wait for their delay to pass and can still be cancelled.
+	PendingActions []pendingPowerAction `json:"PendingActions"`
+
+	// Names of the commands that can be run on the node.
+	Commands []string `json:"Commands"`
+
+	// Set when the node powers itself off after being idle.
+	Idle *nodeIdleStatus `json:"Idle,omitempty"`
+
+	// Maintenance locks that make the node refuse power actions.
+	Locks []maintenanceLock `json:"Locks"`
+}
+
+// nodePowerResponse is returned by the power action endpoints once the action
+// is scheduled or has run, or when a pre-power hook aborted it.
+type nodePowerResponse struct {
+	Status string       `json:"Status"`
+	Hooks  []hookResult `json:"Hooks"`
 }
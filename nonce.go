@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Maximum deviation between the time in a signed action and the local time.
+	requestTimeWindow = time.Minute
+
+	// Maximum number of request IDs remembered at once. Every ID is kept until
+	// its request falls outside requestTimeWindow.
+	maxSeenRequestIDs = 10000
+)
+
+var (
+	errReplayedRequest = errors.New("request ID has already been used")
+	errNonceCacheFull  = errors.New("too many recent requests")
+)
+
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "cannot read random bytes")
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// nonceCache remembers request IDs of recently accepted node requests so that
+// each signed request can only be executed once. The zero value is ready to use.
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// Add records requestID until expires. It fails if the ID was seen before and
+// has not expired yet, or if the cache is full of unexpired IDs.
+func (c *nonceCache) Add(requestID string, expires time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+
+	if exp, ok := c.seen[requestID]; ok && now.Before(exp) {
+		return errReplayedRequest
+	}
+
+	if len(c.seen) >= maxSeenRequestIDs {
+		c.removeExpired(now)
+		if len(c.seen) >= maxSeenRequestIDs {
+			return errNonceCacheFull
+		}
+	}
+
+	c.seen[requestID] = expires
+	return nil
+}
+
+func (c *nonceCache) removeExpired(now time.Time) {
+	for id, exp := range c.seen {
+		if !now.Before(exp) {
+			delete(c.seen, id)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNonceCache(t *testing.T) {
+	var c nonceCache
+	now := time.Now()
+
+	if err := c.Add("a", now.Add(time.Minute)); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := c.Add("a", now.Add(time.Minute)); err != errReplayedRequest {
+		t.Errorf("replay: got %v, want %v", err, errReplayedRequest)
+	}
+
+	if err := c.Add("b", now.Add(-time.Second)); err != nil {
+		t.Fatalf("expired use: %v", err)
+	}
+	if err := c.Add("b", now.Add(time.Minute)); err != nil {
+		t.Errorf("reuse after expiry: %v", err)
+	}
+}
+
+func TestNonceCacheFull(t *testing.T) {
+	var c nonceCache
+	now := time.Now()
+
+	for i := 0; i < maxSeenRequestIDs-1; i++ {
+		if err := c.Add(strconv.Itoa(i), now.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Add("expired", now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Room is made by dropping expired IDs.
+	if err := c.Add("new", now.Add(time.Minute)); err != nil {
+		t.Fatalf("cache with expired IDs: %v", err)
+	}
+	if err := c.Add("full", now.Add(time.Minute)); err != errNonceCacheFull {
+		t.Errorf("full cache: got %v, want %v", err, errNonceCacheFull)
+	}
+}
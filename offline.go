@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultOfflineTimeout = 2 * time.Minute
+	offlinePollInterval   = 3 * time.Second
+)
+
+// offlineVerdict tells whether a remote went offline after it was powered down.
+type offlineVerdict string
+
+const (
+	// The remote neither answers pings nor health requests.
+	verdictConfirmedOff offlineVerdict = "confirmed off"
+
+	// The remote still answered health requests when the timeout expired.
+	verdictStillUp offlineVerdict = "still up"
+
+	// The remote stopped answering health requests but still answers pings, or
+	// could not be checked.
+	verdictUnknown offlineVerdict = "unknown"
+)
+
+var errStillUp = errors.New("remote is still up after it was powered down")
+
+// waitOffline polls the remote with ping and health requests until it is
+// offline, or until OfflineTimeoutSec has passed after delay, the time the
+// remote waits before it executes the power action.
+func (p *program) waitOffline(ctx context.Context, r Remote, delay time.Duration) offlineVerdict {
+	timeout := defaultOfflineTimeout
+	if r.OfflineTimeoutSec > 0 {
+		timeout = time.Duration(r.OfflineTimeoutSec) * time.Second
+	}
+	deadline := time.Now().Add(delay + timeout)
+
+	healthUp := true
+	for {
+		ps, err := p.PingRemote(ctx, r)
+		pingOffline := err == nil && ps == PingStatusOffline
+
+		var nhr nodeHealthResponse
+		nhr, err = p.FetchRemoteHealth(ctx, r)
+		healthUp = err == nil && nhr.Status == "online"
+
+		if pingOffline && !healthUp {
+			return verdictConfirmedOff
+		}
+
+		if time.Now().Add(offlinePollInterval).After(deadline) {
+			break
+		}
+
+		timer := time.NewTimer(offlinePollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return lastOfflineVerdict(healthUp)
+		case <-timer.C:
+		}
+	}
+
+	return lastOfflineVerdict(healthUp)
+}
+
+func lastOfflineVerdict(healthUp bool) offlineVerdict {
+	if healthUp {
+		return verdictStillUp
+	}
+
+	return verdictUnknown
+}
+
+// powerDownDelay returns how long the remote waits before it executes action,
+// which is "power-down", "poweroff" or a sleep action. Synchronous requests are
+// only answered once the action has run.
+func powerDownDelay(r Remote, action string) time.Duration {
+	if !r.Async {
+		return 0
+	}
+
+	if action == scheduleActionPowerDown {
+		action = r.PowerAction
+	}
+	if isSleepAction(action) {
+		return time.Duration(r.SleepDelayMsec) * time.Millisecond
+	}
+
+	return time.Duration(r.PoweroffDelayMsec) * time.Millisecond
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPowerDownDelay(t *testing.T) {
+	r := Remote{Async: true, PoweroffDelayMsec: 5000, SleepDelayMsec: 1000}
+	tests := []struct {
+		remote Remote
+		action string
+		want   time.Duration
+	}{
+		{r, "poweroff", 5 * time.Second},
+		{r, sleepActionSuspend, time.Second},
+		{r, scheduleActionPowerDown, 5 * time.Second},
+		{Remote{Async: true, PoweroffDelayMsec: 5000, SleepDelayMsec: 1000, PowerAction: sleepActionHibernate}, scheduleActionPowerDown, time.Second},
+		{Remote{PoweroffDelayMsec: 5000}, "poweroff", 0},
+	}
+
+	for _, test := range tests {
+		if got := powerDownDelay(test.remote, test.action); got != test.want {
+			t.Errorf("%+v, %s: got %s, want %s", test.remote, test.action, got, test.want)
+		}
+	}
+}
+
+func TestLastOfflineVerdict(t *testing.T) {
+	if got := lastOfflineVerdict(true); got != verdictStillUp {
+		t.Errorf("health up: got %s, want %s", got, verdictStillUp)
+	}
+	if got := lastOfflineVerdict(false); got != verdictUnknown {
+		t.Errorf("health down: got %s, want %s", got, verdictUnknown)
+	}
+}
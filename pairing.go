@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	PairingPort = "2002"
+
+	// How long a node stays in pairing mode waiting for a controller.
+	pairingTimeout = 10 * time.Minute
+
+	// Number of requests with a wrong code after which pairing mode stops.
+	maxPairingAttempts = 3
+)
+
+// pairRequest is sent by the controller to a node in pairing mode. Both sides
+// prove knowledge of the one-time code with a MAC over their own public key and
+// the key ID of the other side, which binds the code to the TLS connection.
+type pairRequest struct {
+	Name      string `json:"Name"`
+	PublicKey string `json:"PublicKey"`
+	MAC       string `json:"MAC"`
+}
+
+type pairResponse struct {
+	PublicKey string `json:"PublicKey"`
+	MAC       string `json:"MAC"`
+}
+
+func pairingMAC(code string, direction string, publicKeyPEM string, peerKeyID string) string {
+	mac := hmac.New(sha256.New, []byte(code))
+	_, _ = mac.Write([]byte("cloudcontrol pairing " + direction + "\n" + publicKeyPEM + "\n" + peerKeyID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func checkPairingMAC(code string, direction string, publicKeyPEM string, peerKeyID string, mac string) bool {
+	expected := pairingMAC(code, direction, publicKeyPEM, peerKeyID)
+	return hmac.Equal([]byte(expected), []byte(mac))
+}
+
+func newPairingCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", errors.Wrap(err, "cannot generate random number")
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func publicKeyPEM(c Config) (string, error) {
+	block, err := encodePublicKeyBlock(c.selfPrivateKey.Public())
+	if err != nil {
+		return "", errors.Wrap(err, "cannot encode self public key")
+	}
+
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+// runPairingMode waits for one controller to pair with this node using a
+// one-time code, and authorizes the key of that controller.
+func runPairingMode() error {
+	c, err := loadConfig()
+	if err != nil {
+		return errors.Wrap(err, "cannot load config")
+	}
+
+	var code string
+	code, err = newPairingCode()
+	if err != nil {
+		return errors.Wrap(err, "cannot create pairing code")
+	}
+
+	var selfPEM string
+	selfPEM, err = publicKeyPEM(c)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Key ID of this node: %s\n", c.selfKeyID)
+	fmt.Printf("Pairing code: %s\n", code)
+	fmt.Printf("Waiting up to %s for a controller to run --pair on port %s.\n", pairingTimeout, PairingPort)
+
+	stdin := bufio.NewReader(os.Stdin)
+	var mu sync.Mutex
+	var attempts int
+	done := make(chan error, 1)
+	finish := func(err error) {
+		select {
+		case done <- err:
+		default:
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pair", func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			_, _ = rw.Write([]byte("HTTP method not allowed"))
+			return
+		}
+
+		var req pairRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(errors.Wrap(err, "cannot JSON decode pair request").Error()))
+			return
+		}
+
+		if !checkPairingMAC(code, "request", req.PublicKey, c.selfKeyID, req.MAC) {
+			attempts++
+			fmt.Printf("Rejected pair request from %s with a wrong code.\n", r.RemoteAddr)
+			if attempts >= maxPairingAttempts {
+				finish(errors.New("too many pair requests with a wrong code"))
+			}
+
+			rw.WriteHeader(http.StatusUnauthorized)
+			_, _ = rw.Write([]byte("Unauthorized"))
+			return
+		}
+
+		key, keyID, err := parsePublicKeyPEM(req.PublicKey)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(errors.Wrap(err, "cannot parse public key").Error()))
+			return
+		}
+
+		fmt.Printf("Controller '%s' at %s wants to pair with key ID %s\n", req.Name, r.RemoteAddr, keyID)
+		accepted, err := promptConfirm(stdin, "Authorize this key? [y/N] ")
+		if err != nil || !accepted {
+			rw.WriteHeader(http.StatusForbidden)
+			_, _ = rw.Write([]byte("Pairing declined on node"))
+			finish(firstError(err, errors.New("pairing declined")))
+			return
+		}
+
+		var path string
+		path, err = writeAuthorizedKey(req.Name, key, nil)
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			_, _ = rw.Write([]byte("Cannot authorize key on node"))
+			finish(errors.Wrap(err, "cannot write authorized key"))
+			return
+		}
+		fmt.Printf("Wrote '%s'.\n", path)
+
+		data, err := json.Marshal(pairResponse{
+			PublicKey: selfPEM,
+			MAC:       pairingMAC(code, "response", selfPEM, keyID),
+		})
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			finish(errors.Wrap(err, "cannot create pair response"))
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(data)
+		finish(nil)
+	})
+
+	server := &http.Server{
+		Addr:      ":" + PairingPort,
+		Handler:   mux,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{c.selfCertificate}, MinVersion: tls.VersionTLS12},
+	}
+	go func() {
+		if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			finish(errors.Wrap(err, "failed to serve HTTPS"))
+		}
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(pairingTimeout):
+		err = errors.New("no controller paired in time")
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFunc()
+	if err2 := server.Shutdown(ctx); err2 != nil {
+		err = firstError(err, errors.Wrap(err2, "cannot shutdown pairing server"))
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Paired. Restart the cloudcontrol service to load the new key.")
+	return nil
+}
+
+// pairWithRemote pairs this controller with a node running in pairing mode. The
+// node key is authorized without scopes, so it identifies the node but grants it
+// no actions here.
+func pairWithRemote(host string) error {
+	c, err := loadConfig()
+	if err != nil {
+		return errors.Wrap(err, "cannot load config")
+	}
+
+	addr := net.JoinHostPort(host, PairingPort)
+	var keyID string
+	keyID, err = fetchCertificateKeyID(addr)
+	if err != nil {
+		return errors.Wrapf(err, "cannot connect to '%s'", addr)
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	fmt.Printf("Remote '%s' has key ID %s\n", host, keyID)
+	var accepted bool
+	accepted, err = promptConfirm(stdin, "Does this match the key ID shown on the remote? [y/N] ")
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return errors.New("pairing declined")
+	}
+
+	var code string
+	code, err = promptLine(stdin, "Pairing code: ")
+	if err != nil {
+		return err
+	}
+
+	var selfPEM string
+	selfPEM, err = publicKeyPEM(c)
+	if err != nil {
+		return err
+	}
+
+	name, _ := os.Hostname()
+	if name == "" {
+		name = "controller"
+	}
+
+	var reqBody []byte
+	reqBody, err = json.Marshal(pairRequest{
+		Name:      name,
+		PublicKey: selfPEM,
+		MAC:       pairingMAC(code, "request", selfPEM, keyID),
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot JSON marshall pair request")
+	}
+
+	// The node asks its operator for confirmation before it responds.
+	client := &http.Client{
+		Timeout: pairingTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   pinnedTLSConfig(keyID, c.selfCertificate),
+			DisableKeepAlives: true,
+		},
+	}
+
+	fmt.Println("Waiting for the remote to confirm.")
+	var resp *http.Response
+	resp, err = client.Post("https://"+addr+"/pair", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return errors.Wrap(err, "cannot send pair request")
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		var b []byte
+		b, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return errors.Wrap(err, "cannot read response")
+		}
+
+		return errors.Errorf("remote returned error: %s", b)
+	}
+
+	var pr pairResponse
+	if err = json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return errors.Wrap(err, "cannot decode JSON response")
+	}
+
+	if !checkPairingMAC(code, "response", pr.PublicKey, c.selfKeyID, pr.MAC) {
+		return errors.New("remote response does not prove knowledge of the pairing code")
+	}
+
+	key, responseKeyID, err := parsePublicKeyPEM(pr.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "cannot parse remote public key")
+	}
+	if responseKeyID != keyID {
+		return errors.Errorf("remote sent key ID '%s' but its certificate has '%s'", responseKeyID, keyID)
+	}
+
+	var path string
+	path, err = writeAuthorizedKey(host, key, []string{})
+	if err != nil {
+		return errors.Wrap(err, "cannot write authorized key")
+	}
+	fmt.Printf("Wrote '%s'.\n", path)
+
+	if err = setRemoteKeyID(c, host, keyID); err != nil {
+		return errors.Wrap(err, "cannot add remote to config")
+	}
+
+	fmt.Println("Paired. Restart the cloudcontrol service to load the new remote.")
+	return nil
+}
+
+// fetchCertificateKeyID connects to addr and returns the key ID of the TLS
+// certificate it presents, without trusting it.
+func fetchCertificateKeyID(addr string) (string, error) {
+	dialer := &net.Dialer{Timeout: remoteRequestTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		// The key ID is shown to the operator for confirmation and then pinned.
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS12,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "cannot connect")
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", errors.New("remote presented no certificate")
+	}
+
+	return keyFingerprint(certs[0].PublicKey)
+}
+
+func promptLine(stdin *bufio.Reader, question string) (string, error) {
+	fmt.Print(question)
+	line, err := stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.Wrap(err, "cannot read answer")
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+func promptConfirm(stdin *bufio.Reader, question string) (bool, error) {
+	answer, err := promptLine(stdin, question)
+	if err != nil {
+		return false, err
+	}
+
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes", nil
+}
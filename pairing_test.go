@@ -0,0 +1,51 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestCheckPairingMAC(t *testing.T) {
+	const (
+		code      = "123456"
+		publicKey = "-----BEGIN PUBLIC KEY-----\n"
+		peerKeyID = "SHA256:peer"
+	)
+	mac := pairingMAC(code, "request", publicKey, peerKeyID)
+
+	tests := []struct {
+		name      string
+		code      string
+		direction string
+		publicKey string
+		peerKeyID string
+		mac       string
+		want      bool
+	}{
+		{"valid", code, "request", publicKey, peerKeyID, mac, true},
+		{"wrong code", "654321", "request", publicKey, peerKeyID, mac, false},
+		{"other direction", code, "response", publicKey, peerKeyID, mac, false},
+		{"other public key", code, "request", publicKey + "x", peerKeyID, mac, false},
+		{"other peer", code, "request", publicKey, "SHA256:other", mac, false},
+		{"empty MAC", code, "request", publicKey, peerKeyID, "", false},
+	}
+
+	for _, test := range tests {
+		if got := checkPairingMAC(test.code, test.direction, test.publicKey, test.peerKeyID, test.mac); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNewPairingCode(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9]{6}$`)
+	for i := 0; i < 100; i++ {
+		code, err := newPairingCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) {
+			t.Fatalf("got code %q, want six digits", code)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errPowerActionCancelled = errors.New("power action was cancelled")
+
+// pendingPowerAction describes a scheduled power action in health responses.
+type pendingPowerAction struct {
+	ID           string `json:"ID"`
+	Action       string `json:"Action"`
+	Deadline     string `json:"Deadline"`
+	RemainingSec int    `json:"RemainingSec"`
+}
+
+type pendingOperation struct {
+	id       string
+	action   string
+	deadline time.Time
+	timer    *time.Timer
+
+	done chan struct{}
+	err  error
+}
+
+// Wait blocks until the operation has run or was cancelled.
+func (o *pendingOperation) Wait() error {
+	<-o.done
+	return o.err
+}
+
+// pendingRegistry keeps track of power actions that wait for their delay to
+// pass, so they can be listed and cancelled. The zero value is ready to use.
+type pendingRegistry struct {
+	mu  sync.Mutex
+	ops map[string]*pendingOperation
+}
+
+// Schedule runs execute after delay, unless the operation is cancelled before.
+func (r *pendingRegistry) Schedule(action string, delay time.Duration, execute func() error) (*pendingOperation, error) {
+	id, err := newRequestID()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create operation ID")
+	}
+
+	op := &pendingOperation{
+		id:       id,
+		action:   action,
+		deadline: time.Now().Add(delay),
+		done:     make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ops == nil {
+		r.ops = make(map[string]*pendingOperation)
+	}
+	r.ops[id] = op
+
+	op.timer = time.AfterFunc(delay, func() {
+		r.mu.Lock()
+		delete(r.ops, id)
+		r.mu.Unlock()
+
+		op.err = execute()
+		close(op.done)
+	})
+
+	return op, nil
+}
+
+// Cancel stops the pending operation with the given ID, or all pending
+// operations if id is empty. It returns the number of cancelled operations.
+func (r *pendingRegistry) Cancel(id string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cancelled := 0
+	for opID, op := range r.ops {
+		if id != "" && opID != id {
+			continue
+		}
+
+		// Stop fails if the timer already fired; the operation is then running.
+		if op.timer.Stop() {
+			delete(r.ops, opID)
+			op.err = errPowerActionCancelled
+			close(op.done)
+			cancelled++
+		}
+	}
+
+	return cancelled
+}
+
+// List returns the pending operations, soonest first.
+func (r *pendingRegistry) List() []pendingPowerAction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	list := make([]pendingPowerAction, 0, len(r.ops))
+	for _, op := range r.ops {
+		list = append(list, pendingPowerAction{
+			ID:           op.id,
+			Action:       op.action,
+			Deadline:     op.deadline.Format(time.RFC3339),
+			RemainingSec: int(op.deadline.Sub(now).Round(time.Second) / time.Second),
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Deadline < list[j].Deadline
+	})
+
+	return list
+}
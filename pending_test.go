@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPendingRegistry(t *testing.T) {
+	var r pendingRegistry
+
+	ran := make(chan struct{})
+	soon, err := r.Schedule("poweroff", 10*time.Millisecond, func() error {
+		close(ran)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	late, err := r.Schedule("reboot", time.Hour, func() error {
+		t.Error("cancelled operation ran")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := r.List()
+	if len(list) != 2 || list[0].ID != soon.id || list[1].ID != late.id {
+		t.Fatalf("got %+v, want the poweroff before the reboot", list)
+	}
+
+	if err := soon.Wait(); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+	<-ran
+
+	if n := r.Cancel("unknown"); n != 0 {
+		t.Errorf("cancelled %d operations with an unknown ID, want 0", n)
+	}
+	if n := r.Cancel(late.id); n != 1 {
+		t.Errorf("cancelled %d operations, want 1", n)
+	}
+	if err := late.Wait(); err != errPowerActionCancelled {
+		t.Errorf("got %v, want %v", err, errPowerActionCancelled)
+	}
+	if list := r.List(); len(list) != 0 {
+		t.Errorf("got %+v, want no pending operations", list)
+	}
+}
+
+func TestPendingRegistryCancelAll(t *testing.T) {
+	var r pendingRegistry
+	for _, action := range []string{"poweroff", "suspend"} {
+		if _, err := r.Schedule(action, time.Hour, func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := r.Cancel(""); n != 2 {
+		t.Errorf("cancelled %d operations, want 2", n)
+	}
+}
+
+func TestNodeExecuteCancelHandler(t *testing.T) {
+	p := newTestNode(t)
+	if _, err := p.pending.Schedule("poweroff", time.Hour, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		want int
+	}{
+		{"unknown ID", "unknown", http.StatusNotFound},
+		{"all", "", http.StatusOK},
+		{"nothing left", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := serveNodeRequest(t, p, p.nodeExecuteCancelHandler, "/node/execute/cancel", &nodeCancelAction{ID: tt.id})
+		if rec.Code != tt.want {
+			t.Errorf("%s: got status %d (%s), want %d", tt.name, rec.Code, rec.Body.String(), tt.want)
+		}
+	}
+}
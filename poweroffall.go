@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Policies for a failing remote while powering off all remotes and self.
+const (
+	// Stop at the first failing remote and keep this node up.
+	poweroffAllPolicyAbort = "abort"
+
+	// Power down the other remotes and this node anyway.
+	poweroffAllPolicyContinue = "continue"
+
+	// Power down the other remotes, but keep this node up to fix the failed ones.
+	poweroffAllPolicyContinueKeepSelf = "continue-keep-self"
+)
+
+const selfHost = "self"
+
+var errKeptUp = errors.New("kept up because a remote failed")
+
+// poweroffAllResult is the outcome of powering down all remotes and this node.
+type poweroffAllResult struct {
+	Remotes []fleetResult
+
+	// Has Err set when this node must stay up.
+	Self fleetResult
+}
+
+// All returns the results of the remotes followed by the one of this node.
+func (r poweroffAllResult) All() []fleetResult {
+	return append(append([]fleetResult(nil), r.Remotes...), r.Self)
+}
+
+type poweroffAllData struct {
+	UriKey    string
+	Rows      []poweroffAllDataRow
+	AnyLocked bool
+}
+
+type poweroffAllDataRow struct {
+	Host    string
+	Outcome string
+	Hooks   []string
+}
+
+func validatePoweroffAllPolicy(policy string) error {
+	switch policy {
+	case "", poweroffAllPolicyAbort, poweroffAllPolicyContinue, poweroffAllPolicyContinueKeepSelf:
+		return nil
+	default:
+		return errors.Errorf("unknown PoweroffAllPolicy '%s'", policy)
+	}
+}
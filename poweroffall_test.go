@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoweroffAllResultsPageEscapes(t *testing.T) {
+	chdirTemp(t)
+
+	const script = "<script>alert(1)</script>"
+	err := acquireLock(maintenanceLock{Name: "backup", Owner: script, Reason: "running", CreatedAt: time.Now().Format(time.RFC3339)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, executor := newDryRunNode(t)
+	p.Config.WebAdmin.UriKey = "k&y"
+	p.Config.Node.PrePowerHooks = []PowerHook{{Name: script, Argv: []string{"false"}, AbortOnFailure: true}}
+
+	rec := httptest.NewRecorder()
+	p.webadminExecutePoweroffAllAndSelfHandler(rec, httptest.NewRequest(http.MethodPost, "/webadmin/execute/poweroff-all-and-self", nil))
+	if rec.Code != http.StatusLocked {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusLocked)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, script) {
+		t.Fatalf("lock owner is not escaped:\n%s", body)
+	}
+	if !strings.Contains(body, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Fatalf("lock owner is missing:\n%s", body)
+	}
+	if !strings.Contains(body, "poweroff-all-and-self?key=k%26y&force=true") {
+		t.Fatalf("force form with escaped key is missing:\n%s", body)
+	}
+
+	// Forced, the failing hook aborts the poweroff of self.
+	rec = httptest.NewRecorder()
+	p.webadminExecutePoweroffAllAndSelfHandler(rec, httptest.NewRequest(http.MethodPost, "/webadmin/execute/poweroff-all-and-self?force=true", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("forced: got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	body = rec.Body.String()
+	if strings.Contains(body, script) || !strings.Contains(body, "hook &#39;&lt;script&gt;") {
+		t.Fatalf("forced: hook name is not escaped:\n%s", body)
+	}
+	if got := recordedActions(executor); len(got) != 0 {
+		t.Fatalf("forced: got actions %v, want none", got)
+	}
+}
+
+func TestPowerDownRemotesAndSelfPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		selfErr error
+	}{
+		{"", errAborted},
+		{poweroffAllPolicyAbort, errAborted},
+		{poweroffAllPolicyContinue, nil},
+		{poweroffAllPolicyContinueKeepSelf, errKeptUp},
+	}
+
+	for _, test := range tests {
+		chdirTemp(t)
+		p := newTestNode(t)
+		p.Config.WebAdmin.Remotes = []Remote{{Host: "nas"}, {Host: "web", DependsOn: []string{"nas"}}}
+		p.Config.WebAdmin.PoweroffAllPolicy = test.policy
+
+		// Without time left, every remote fails before it is contacted.
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		cancelFunc()
+
+		result, err := p.powerDownRemotesAndSelf(ctx, p.Config.WebAdmin.Remotes, false)
+		if err != nil {
+			t.Fatalf("%q: %v", test.policy, err)
+		}
+		if result.Self.Err != test.selfErr {
+			t.Errorf("%q: got self error %v, want %v", test.policy, result.Self.Err, test.selfErr)
+		}
+		if len(result.Remotes) != 2 || result.Remotes[0].Err == nil {
+			t.Errorf("%q: got remote results %+v, want web failed", test.policy, result.Remotes)
+		}
+	}
+}
+
+func TestValidatePoweroffAllPolicy(t *testing.T) {
+	for _, policy := range []string{"", "abort", "continue", "continue-keep-self"} {
+		if err := validatePoweroffAllPolicy(policy); err != nil {
+			t.Errorf("%q: %v", policy, err)
+		}
+	}
+	if err := validatePoweroffAllPolicy("ignore"); err == nil {
+		t.Error("unknown policy: got no error")
+	}
+}
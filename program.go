@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/kardianos/service"
@@ -19,12 +20,39 @@ type program struct {
 	Webadmin bool
 	Config   Config
 
+	// Carries out the power actions of this node.
+	Executor powerExecutor
+
+	// Request IDs of node requests accepted recently.
+	nonces nonceCache
+
+	// Power actions waiting for their delay to pass.
+	pending pendingRegistry
+
+	// Runs the schedule rules of the config.
+	scheduler *scheduler
+
+	// Tracks for how long this node has been idle.
+	idle idleMonitor
+
 	t tomb.Tomb
 }
 
 func (p *program) Start(service.Service) error {
 	// Start should not block. Do the actual work async.
+	var err error
+	p.scheduler, err = newScheduler(p.Config.WebAdmin.Schedules, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "cannot create scheduler")
+	}
+
 	p.t.Go(p.run)
+	if len(p.scheduler.rules) > 0 {
+		p.t.Go(p.runScheduler)
+	}
+	if p.Config.Node.Idle.PoweroffAfterSec > 0 {
+		p.t.Go(p.runIdleMonitor)
+	}
 	return nil
 }
 
@@ -35,12 +63,11 @@ func (p *program) Stop(service.Service) error {
 }
 
 func (p *program) run() error {
-	p.configureHTTPClient()
-	server := &http.Server{Addr: ":" + HTTPPort, Handler: p.newMux()}
+	server := &http.Server{Addr: ":" + HTTPPort, Handler: p.newMux(), TLSConfig: p.serverTLSConfig()}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			_ = p.Logger.Error(errors.Wrap(err, "failed to serve HTTP"))
+		if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			_ = p.Logger.Error(errors.Wrap(err, "failed to serve HTTPS"))
 		}
 	}()
 
@@ -65,9 +92,72 @@ func (p *program) validateConfig() error {
 		}
 	}
 
+	for name, command := range p.Config.Node.Commands {
+		if name == "" || strings.Contains(name, "/") {
+			return errors.Errorf("invalid command name '%s'", name)
+		}
+		if len(command.Argv) == 0 {
+			return errors.Errorf("no Argv set for command '%s'", name)
+		}
+	}
+
+	if err := validatePowerHooks(p.Config.Node.PrePowerHooks); err != nil {
+		return err
+	}
+
+	if idle := p.Config.Node.Idle; idle.PoweroffAfterSec < 0 || idle.CheckIntervalSec < 0 || idle.MaxLoadAverage < 0 || idle.MaxNetworkBytesPerSec < 0 {
+		return errors.New("negative value in Node.Idle")
+	}
+
+	if err := validateBootSequence(p.Config.WebAdmin.Remotes, p.Config.WebAdmin.BootSequence); err != nil {
+		return err
+	}
+
+	if err := validatePoweroffAllPolicy(p.Config.WebAdmin.PoweroffAllPolicy); err != nil {
+		return err
+	}
+
+	if err := validateDependencies(p.Config.WebAdmin.Remotes); err != nil {
+		return err
+	}
+
+	if err := p.validateScheduleRules(); err != nil {
+		return err
+	}
+
+	for _, remote := range p.Config.WebAdmin.Remotes {
+		if remote.KeyID == "" {
+			return errors.Errorf("no KeyID set for remote '%s' in config, set it to the key ID printed by --fingerprint on the remote", remote.Host)
+		}
+		if remote.PowerAction != "" && remote.PowerAction != "poweroff" && !isSleepAction(remote.PowerAction) {
+			return errors.Errorf("unknown PowerAction '%s' for remote '%s'", remote.PowerAction, remote.Host)
+		}
+		if remote.MAC != "" {
+			if _, err := parseWakeMAC(remote.MAC); err != nil {
+				return errors.Wrapf(err, "invalid MAC for remote '%s'", remote.Host)
+			}
+		}
+	}
+
 	return nil
 }
 
-func (p *program) configureHTTPClient() {
-	http.DefaultClient.Timeout = 5 * time.Second
+func (p *program) remoteByHost(host string) (Remote, bool) {
+	for _, remote := range p.Config.WebAdmin.Remotes {
+		if remote.Host == host {
+			return remote, true
+		}
+	}
+
+	return Remote{}, false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testLogger logs through t, so the output only shows up for failing tests.
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Error(v ...interface{}) error {
+	l.t.Log(append([]interface{}{"error:"}, v...)...)
+	return nil
+}
+
+func (l testLogger) Warning(v ...interface{}) error {
+	l.t.Log(append([]interface{}{"warning:"}, v...)...)
+	return nil
+}
+
+func (l testLogger) Info(v ...interface{}) error {
+	l.t.Log(append([]interface{}{"info:"}, v...)...)
+	return nil
+}
+
+func (l testLogger) Errorf(format string, a ...interface{}) error {
+	l.t.Log("error: " + fmt.Sprintf(format, a...))
+	return nil
+}
+
+func (l testLogger) Warningf(format string, a ...interface{}) error {
+	l.t.Log("warning: " + fmt.Sprintf(format, a...))
+	return nil
+}
+
+func (l testLogger) Infof(format string, a ...interface{}) error {
+	l.t.Log("info: " + fmt.Sprintf(format, a...))
+	return nil
+}
+
+func newTestKey(t *testing.T) crypto.Signer {
+	t.Helper()
+
+	key, err := generateKey(keyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// chdirTemp runs the rest of the test in a new temporary directory, so config
+// and key files do not leak between tests.
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+
+	return dir
+}
+
+func testKeyID(t *testing.T, publicKey crypto.PublicKey) string {
+	t.Helper()
+
+	keyID, err := keyFingerprint(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keyID
+}
+
+// newTestNode returns a node that authorizes its own self key, so that it can
+// send signed requests to itself.
+func newTestNode(t *testing.T) *program {
+	t.Helper()
+
+	key := newTestKey(t)
+	p := &program{Logger: testLogger{t}, scheduler: &scheduler{}}
+	p.Config.selfPrivateKey = key
+	p.Config.selfKeyID = testKeyID(t, key.Public())
+	p.Config.revocations = newRevocationStore()
+	p.Config.authorizedKeys = newKeyring(map[string]authorizedKey{
+		p.Config.selfKeyID: {Path: "self.pub", Key: key.Public()},
+	})
+
+	var err error
+	p.Config.selfCertificate, err = newSelfCertificate(p.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+// newDryRunNode returns a test node that records power actions instead of
+// executing them.
+func newDryRunNode(t *testing.T) (*program, *dryRunExecutor) {
+	t.Helper()
+
+	p := newTestNode(t)
+	executor := &dryRunExecutor{logger: testLogger{t}}
+	p.Executor = executor
+
+	return p, executor
+}
+
+// serveNodeRequest signs a request for action at endpoint of 127.0.0.1 and
+// serves it with handler.
+func serveNodeRequest(t *testing.T, p *program, handler http.HandlerFunc, endpoint string, action actionInterface) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, _, err := p.newNodeRequest(context.Background(), Remote{Host: "127.0.0.1"}, endpoint, action)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return serveRequest(handler, req)
+}
+
+func serveRequest(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestValidateConfig(t *testing.T) {
+	p := newTestNode(t)
+	p.Config.WebAdmin.Remotes = []Remote{{Host: "10.0.0.2", KeyID: "SHA256:remote"}}
+	if err := p.validateConfig(); err != nil {
+		t.Errorf("pinned remote: %v", err)
+	}
+
+	p.Config.WebAdmin.Remotes = append(p.Config.WebAdmin.Remotes, Remote{Host: "10.0.0.3"})
+	if err := p.validateConfig(); err == nil || !strings.Contains(err.Error(), "10.0.0.3") {
+		t.Errorf("unpinned remote: got %v, want error naming the remote", err)
+	}
+}
+
+func TestValidateConfigPowerAction(t *testing.T) {
+	tests := []struct {
+		powerAction string
+		wantErr     bool
+	}{
+		{"", false},
+		{"poweroff", false},
+		{sleepActionSuspend, false},
+		{sleepActionHibernate, false},
+		{sleepActionHybridSleep, false},
+		{"reboot", true},
+		{"sleep", true},
+	}
+
+	for _, test := range tests {
+		p := newTestNode(t)
+		p.Config.WebAdmin.Remotes = []Remote{{Host: "10.0.0.2", KeyID: "SHA256:remote", PowerAction: test.powerAction}}
+		if err := p.validateConfig(); (err != nil) != test.wantErr {
+			t.Errorf("PowerAction %q: got error %v, want error %v", test.powerAction, err, test.wantErr)
+		}
+	}
+}
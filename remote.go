@@ -2,114 +2,418 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/url"
 	"os/exec"
-	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
 type Remote struct {
-	Host              string
+	Host string
+
+	// Key ID of the self key of the remote. The remote must present a TLS
+	// certificate for this key.
+	KeyID string
+
+	// Action that powers down the remote along with the fleet: "poweroff" (the
+	// default) or one of the sleep actions "suspend", "hibernate" and
+	// "hybrid-sleep".
+	PowerAction string
+
 	Async             bool
 	PoweroffDelayMsec int
+	RebootDelayMsec   int
+	SleepDelayMsec    int
+
+	// MAC address to send Wake-on-LAN magic packets for.
+	MAC string
+
+	// Address the magic packets are sent to, with an optional port. Defaults to
+	// the broadcast address of WakeInterface, or 255.255.255.255:9.
+	WakeBroadcast string
+
+	// Network interface to send the magic packets from.
+	WakeInterface string
+
+	// Hosts of the remotes this remote needs, like a NAS it mounts. A fleet
+	// shutdown powers this remote down before them.
+	DependsOn []string
+
+	// How long to wait for the remote to go offline after it was powered down,
+	// on top of its delay. Defaults to two minutes.
+	OfflineTimeoutSec int
+
+	// How long to wait for named commands to finish. Defaults to two minutes.
+	CommandTimeoutSec int
+
+	// How long to wait for power actions to be answered, including the
+	// pre-power hooks of the remote. Defaults to two minutes.
+	PowerTimeoutSec int
 }
 
-func (p *program) PoweroffRemote(r Remote) error {
-	resp, err := p.DoRemoteRequest(r, "/node/execute/poweroff", &nodePoweroffAction{
+const (
+	defaultRemoteCommandTimeout = 2 * time.Minute
+	defaultRemotePowerTimeout   = 2 * time.Minute
+)
+
+// PowerDownRemote powers off the remote or puts it to sleep, depending on its
+// PowerAction. Remotes that hold maintenance locks refuse unless force is set.
+func (p *program) PowerDownRemote(ctx context.Context, r Remote, force bool) ([]hookResult, error) {
+	if isSleepAction(r.PowerAction) {
+		return p.SleepRemote(ctx, r, r.PowerAction, force)
+	}
+
+	return p.PoweroffRemote(ctx, r, force)
+}
+
+func (p *program) PoweroffRemote(ctx context.Context, r Remote, force bool) ([]hookResult, error) {
+	return p.powerRemote(ctx, r, "/node/execute/poweroff", &nodePoweroffAction{
 		Async:             r.Async,
 		PoweroffDelayMsec: r.PoweroffDelayMsec,
+		Force:             force,
+	})
+}
+
+func (p *program) RebootRemote(ctx context.Context, r Remote, force bool) ([]hookResult, error) {
+	return p.powerRemote(ctx, r, "/node/execute/reboot", &nodeRebootAction{
+		Async:           r.Async,
+		RebootDelayMsec: r.RebootDelayMsec,
+		Force:           force,
+	})
+}
+
+// SleepRemote puts the remote in one of the sleepActions.
+func (p *program) SleepRemote(ctx context.Context, r Remote, action string, force bool) ([]hookResult, error) {
+	return p.powerRemote(ctx, r, "/node/execute/"+action, &nodeSleepAction{
+		Async:          r.Async,
+		SleepDelayMsec: r.SleepDelayMsec,
+		Force:          force,
+		Action:         action,
+	})
+}
+
+// LockRemote makes the remote refuse power actions that are not forced until the
+// lock is released or its ttl has passed. A zero ttl holds the lock until it is
+// released.
+func (p *program) LockRemote(ctx context.Context, r Remote, name string, owner string, reason string, ttl time.Duration) error {
+	resp, err := p.DoRemoteRequest(ctx, r, "/node/locks/acquire", &nodeLockAction{
+		Name:   name,
+		Owner:  owner,
+		Reason: reason,
+		TTLSec: int(ttl / time.Second),
 	})
 	if err != nil {
-		_ = p.Logger.Error(errors.Wrapf(err, "cannot poweroff remote '%s'", r.Host))
-		return nil
+		return errors.Wrap(err, "cannot send request")
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
 
 	if resp.StatusCode != http.StatusOK {
-		var b []byte
-		b, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return errors.Wrap(err, "cannot read response")
-		}
+		return remoteStatusError(resp.StatusCode, resp.Body)
+	}
 
-		if strings.Contains(string(b), "signal: terminated") {
-			return nil
-		}
+	return nil
+}
 
-		return errors.Errorf("remote returned error: %s", b)
+func (p *program) UnlockRemote(ctx context.Context, r Remote, name string) error {
+	resp, err := p.DoRemoteRequest(ctx, r, "/node/locks/release", &nodeUnlockAction{Name: name})
+	if err != nil {
+		return errors.Wrap(err, "cannot send request")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return remoteStatusError(resp.StatusCode, resp.Body)
+	}
+
+	return nil
+}
+
+// CancelRemotePending cancels the pending power action with the given ID on the
+// remote, or all of them if id is empty.
+func (p *program) CancelRemotePending(ctx context.Context, r Remote, id string) error {
+	resp, err := p.DoRemoteRequest(ctx, r, "/node/execute/cancel", &nodeCancelAction{ID: id})
+	if err != nil {
+		return errors.Wrap(err, "cannot send request")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return remoteStatusError(resp.StatusCode, resp.Body)
+	}
+
+	return nil
+}
+
+// KeepRemoteAwake stops the remote from powering off for being idle for the
+// given duration. A zero duration ends an earlier keep awake.
+func (p *program) KeepRemoteAwake(ctx context.Context, r Remote, duration time.Duration) error {
+	resp, err := p.DoRemoteRequest(ctx, r, "/node/keep-awake", &nodeKeepAwakeAction{DurationSec: int(duration / time.Second)})
+	if err != nil {
+		return errors.Wrap(err, "cannot send request")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return remoteStatusError(resp.StatusCode, resp.Body)
 	}
 
 	return nil
 }
 
-func (p *program) FetchRemoteHealth(r Remote) (nodeHealthResponse, error) {
+// RunRemoteCommand runs the named command on the remote and returns its result.
+func (p *program) RunRemoteCommand(ctx context.Context, r Remote, name string) (nodeCommandResponse, error) {
+	ncr := nodeCommandResponse{}
+	timeout := defaultRemoteCommandTimeout
+	if r.CommandTimeoutSec > 0 {
+		timeout = time.Duration(r.CommandTimeoutSec) * time.Second
+	}
+
+	resp, err := p.doRemoteRequest(ctx, r, "/node/execute/command/"+url.PathEscape(name), &nodeCommandAction{Name: name}, timeout)
+	if err != nil {
+		return ncr, errors.Wrap(err, "cannot send request")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return ncr, remoteStatusError(resp.StatusCode, resp.Body)
+	}
+
+	if err = json.Unmarshal(resp.Body, &ncr); err != nil {
+		return ncr, errors.Wrap(err, "cannot decode JSON response")
+	}
+
+	return ncr, nil
+}
+
+// powerRemote sends a power action to the remote and returns the results of its
+// pre-power hooks. A remote that executes the action synchronously may go down
+// before its response arrives, so connection errors are returned as
+// ErrRemoteNoResponse for the caller to check whether the remote went offline.
+func (p *program) powerRemote(ctx context.Context, r Remote, endpoint string, action actionInterface) ([]hookResult, error) {
+	timeout := defaultRemotePowerTimeout
+	if r.PowerTimeoutSec > 0 {
+		timeout = time.Duration(r.PowerTimeoutSec) * time.Second
+	}
+
+	resp, err := p.doRemoteRequest(ctx, r, endpoint, action, timeout)
+	if err != nil {
+		if errors.Is(err, ErrUntrustedResponse) {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			// The fleet deadline passed, which says nothing about the remote.
+			return nil, errors.Wrap(ctx.Err(), "no response before the deadline")
+		}
+
+		return nil, errors.WithMessagef(ErrRemoteNoResponse, "%v", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		npr := nodePowerResponse{}
+		if err = json.Unmarshal(resp.Body, &npr); err != nil {
+			// Remotes without pre-power hook support answer in plain text.
+			return nil, nil
+		}
+
+		return npr.Hooks, nil
+	case http.StatusFailedDependency:
+		npr := nodePowerResponse{}
+		if err = json.Unmarshal(resp.Body, &npr); err != nil {
+			return nil, errors.Wrap(err, "cannot decode JSON response")
+		}
+
+		return npr.Hooks, errors.WithMessagef(ErrHookAborted, "%s", hookReport(npr.Hooks))
+	default:
+		return nil, remoteStatusError(resp.StatusCode, resp.Body)
+	}
+}
+
+func (p *program) FetchRemoteHealth(ctx context.Context, r Remote) (nodeHealthResponse, error) {
 	nhr := nodeHealthResponse{}
-	resp, err := p.DoRemoteRequest(r, "/node/health", &nodeHealthAction{})
+	resp, err := p.DoRemoteRequest(ctx, r, "/node/health", &nodeHealthAction{})
 	if err != nil {
+		if errors.Is(err, ErrUntrustedResponse) {
+			_ = p.Logger.Warning(errors.Wrapf(err, "health of remote '%s' cannot be trusted", r.Host))
+			return nodeHealthResponse{Status: "untrusted"}, nil
+		}
+
 		_ = p.Logger.Error(errors.Wrapf(err, "cannot fetch remote '%s' health", r.Host))
 		return nodeHealthResponse{Status: "offline"}, nil
 	}
 
 	if resp.StatusCode == http.StatusOK {
-		if err = json.NewDecoder(resp.Body).Decode(&nhr); err != nil {
+		if err = json.Unmarshal(resp.Body, &nhr); err != nil {
 			return nhr, errors.Wrap(err, "cannot decode JSON response")
 		}
 
 		return nhr, nil
 	} else {
-		var b []byte
-		b, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return nhr, errors.Wrap(err, "cannot read response")
-		}
+		return nhr, remoteStatusError(resp.StatusCode, resp.Body)
+	}
+}
 
-		return nhr, errors.Errorf("remote returned error: %s", b)
+// RotateRemoteKey authorizes newPublicKeyPEM on the remote in place of the current
+// self key, which the remote retires after gracePeriod.
+func (p *program) RotateRemoteKey(ctx context.Context, r Remote, newPublicKeyPEM string, gracePeriod time.Duration) error {
+	resp, err := p.DoRemoteRequest(ctx, r, "/node/keys/rotate", &nodeRotateKeyAction{
+		NewPublicKey:   newPublicKeyPEM,
+		GracePeriodSec: int(gracePeriod / time.Second),
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot send request")
 	}
+
+	if resp.StatusCode != http.StatusOK {
+		return remoteStatusError(resp.StatusCode, resp.Body)
+	}
+
+	return nil
+}
+
+// SendRemoteRevocations sends a signed revocation list to the remote. Remotes
+// ignore lists that are not newer than the one they have.
+func (p *program) SendRemoteRevocations(ctx context.Context, r Remote, signed signedRevocationList) error {
+	resp, err := p.DoRemoteRequest(ctx, r, "/node/revocations", &nodeRevocationsAction{List: signed})
+	if err != nil {
+		return errors.Wrap(err, "cannot send request")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return remoteStatusError(resp.StatusCode, resp.Body)
+	}
+
+	return nil
 }
 
-func (p *program) DoRemoteRequest(r Remote, endpoint string, action actionInterface) (*http.Response, error) {
+// remoteStatusError converts an unsuccessful node response to an error. Signature,
+// scope and replay rejections and cancellations wrap their own error values so
+// that callers can tell them apart.
+func remoteStatusError(statusCode int, body []byte) error {
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return errors.WithMessagef(ErrRemoteUnauthorized, "remote returned error: %s", body)
+	case http.StatusForbidden:
+		return errors.WithMessagef(ErrRemoteForbidden, "remote returned error: %s", body)
+	case http.StatusConflict:
+		return errors.WithMessagef(ErrRemoteReplayRejected, "remote returned error: %s", body)
+	case http.StatusGone:
+		return errors.WithMessagef(ErrRemoteActionCancelled, "remote returned error: %s", body)
+	case http.StatusLocked:
+		return errors.WithMessagef(ErrLocked, "remote returned error: %s", body)
+	case http.StatusInternalServerError:
+		return errors.WithMessagef(ErrRemoteFailed, "remote returned error: %s", body)
+	default:
+		return errors.Errorf("remote returned error: %s", body)
+	}
+}
+
+// remoteResponse is a node response whose signature has been verified.
+type remoteResponse struct {
+	StatusCode int
+	Body       []byte
+}
+
+// DoRemoteRequest sends a signed action to the remote and verifies that the
+// response is signed by the pinned key of the remote. A response that fails
+// verification is returned as an error wrapping ErrUntrustedResponse.
+func (p *program) DoRemoteRequest(ctx context.Context, r Remote, endpoint string, action actionInterface) (*remoteResponse, error) {
+	return p.doRemoteRequest(ctx, r, endpoint, action, remoteRequestTimeout)
+}
+
+func (p *program) doRemoteRequest(ctx context.Context, r Remote, endpoint string, action actionInterface, timeout time.Duration) (*remoteResponse, error) {
+	req, requestID, err := p.newNodeRequest(ctx, r, endpoint, action)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *http.Response
+	resp, err = p.remoteHTTPClient(r, timeout).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var respBody []byte
+	respBody, err = io.ReadAll(io.LimitReader(resp.Body, maxNodeResponseSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read response")
+	}
+
+	if err = verifyNodeResponse(r, requestID, req.URL.Path, resp, respBody); err != nil {
+		return nil, errors.WithMessage(ErrUntrustedResponse, err.Error())
+	}
+
+	return &remoteResponse{StatusCode: resp.StatusCode, Body: respBody}, nil
+}
+
+// newNodeRequest returns a request for action at endpoint of the remote, signed
+// with the self key, and the ID of the request.
+func (p *program) newNodeRequest(ctx context.Context, r Remote, endpoint string, action actionInterface) (*http.Request, string, error) {
+	requestID, err := newRequestID()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "cannot create request ID")
+	}
+
 	action.SetCurrentTime(time.Now())
-	reqBody, err := json.Marshal(action)
+	action.SetRequestID(requestID)
+	var actionBody []byte
+	actionBody, err = json.Marshal(action)
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot JSON marshall request body")
+		return nil, "", errors.Wrap(err, "cannot JSON marshall action")
+	}
+
+	// The endpoint may contain escaped path segments. The node signs and checks
+	// the unescaped path.
+	var u *url.URL
+	u, err = url.Parse("https://" + r.Host + ":" + HTTPPort + endpoint)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "cannot parse request URL")
+	}
+
+	var reqBody []byte
+	reqBody, err = json.Marshal(nodeRequestEnvelope{
+		ActionType: action.ActionType(),
+		Method:     http.MethodPost,
+		Path:       u.Path,
+		Host:       r.Host,
+		Action:     actionBody,
+	})
+	if err != nil {
+		return nil, "", errors.Wrap(err, "cannot JSON marshall request body")
 	}
 
 	var signature []byte
 	signature, err = signMessage(reqBody, p.Config.selfPrivateKey)
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot sign request message")
+		return nil, "", errors.Wrap(err, "cannot sign request message")
 	}
 
 	var signatureBytes bytes.Buffer
 	enc := base64.NewEncoder(base64.StdEncoding, &signatureBytes)
 	_, err = enc.Write(signature)
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot write signature to base64 encoder")
+		return nil, "", errors.Wrap(err, "cannot write signature to base64 encoder")
 	}
 	if err = enc.Close(); err != nil {
-		return nil, errors.Wrap(err, "cannot close base64 encoder")
+		return nil, "", errors.Wrap(err, "cannot close base64 encoder")
 	}
 
-	//goland:noinspection HttpUrlsUsage
-	url := "http://" + r.Host + ":" + HTTPPort + endpoint
-
 	var req *http.Request
-	req, err = http.NewRequest(http.MethodPost, url, bytes.NewBuffer(reqBody))
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewBuffer(reqBody))
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot create request")
+		return nil, "", errors.Wrap(err, "cannot create request")
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Signature", signatureBytes.String())
+	req.Header.Set("X-Key-Id", p.Config.selfKeyID)
+	req.Header.Set("X-Request-Id", requestID)
 
-	return http.DefaultClient.Do(req)
+	return req, requestID, nil
 }
 
 type pingStatus string
@@ -120,9 +424,9 @@ const (
 	PingStatusError   pingStatus = "error"
 )
 
-func (p *program) PingRemote(r Remote) (pingStatus, error) {
+func (p *program) PingRemote(ctx context.Context, r Remote) (pingStatus, error) {
 	errBuf := bytes.Buffer{}
-	cmd := exec.Command("ping", r.Host, "-c", "1", "-w", "1", "-q")
+	cmd := exec.CommandContext(ctx, "ping", r.Host, "-c", "1", "-w", "1", "-q")
 	cmd.Stderr = &errBuf
 	if err := cmd.Run(); err != nil {
 		if ee, ok := err.(*exec.ExitError); ok {
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRemoteStatusError(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		want       error
+	}{
+		{http.StatusUnauthorized, ErrRemoteUnauthorized},
+		{http.StatusForbidden, ErrRemoteForbidden},
+		{http.StatusConflict, ErrRemoteReplayRejected},
+		{http.StatusGone, ErrRemoteActionCancelled},
+		{http.StatusLocked, ErrLocked},
+		{http.StatusInternalServerError, ErrRemoteFailed},
+		{http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		err := remoteStatusError(test.statusCode, []byte("body"))
+		if err == nil {
+			t.Errorf("status %d: got no error", test.statusCode)
+			continue
+		}
+		if test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("status %d: got %v, want %v", test.statusCode, err, test.want)
+		}
+	}
+}
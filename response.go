@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Maximum size of a node response read by the controller.
+const maxNodeResponseSize = 4 << 20
+
+// signingResponseWriter buffers a node response so that it can be signed before
+// it is sent.
+type signingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *signingResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *signingResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+
+	return w.body.Write(b)
+}
+
+// signResponses signs every response of next with the self key, binding it to
+// the request ID the controller sent.
+func (p *program) signResponses(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		srw := &signingResponseWriter{ResponseWriter: rw}
+		next(srw, r)
+		if srw.statusCode == 0 {
+			srw.statusCode = http.StatusOK
+		}
+
+		now := time.Now().Format(time.RFC3339)
+		message, err := json.Marshal(nodeResponseEnvelope{
+			RequestID:   r.Header.Get("X-Request-Id"),
+			Path:        r.URL.Path,
+			StatusCode:  srw.statusCode,
+			CurrentTime: now,
+			Body:        srw.body.Bytes(),
+		})
+		if err != nil {
+			_ = p.Logger.Error(errors.Wrap(err, "cannot create response envelope"))
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var signature []byte
+		signature, err = signMessage(message, p.Config.selfPrivateKey)
+		if err != nil {
+			_ = p.Logger.Error(errors.Wrap(err, "cannot sign response"))
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("X-Signature", base64.StdEncoding.EncodeToString(signature))
+		rw.Header().Set("X-Key-Id", p.Config.selfKeyID)
+		rw.Header().Set("X-Response-Time", now)
+		rw.WriteHeader(srw.statusCode)
+		_, _ = rw.Write(srw.body.Bytes())
+	}
+}
+
+// verifyNodeResponse checks that resp answers the request with requestID and is
+// signed by the key pinned for r. The pinned key was already checked during the
+// TLS handshake, so the certificate provides the public key.
+func verifyNodeResponse(r Remote, requestID string, endpoint string, resp *http.Response, body []byte) error {
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return errors.New("response did not arrive over TLS")
+	}
+
+	keyID := resp.Header.Get("X-Key-Id")
+	if keyID != r.KeyID {
+		return errors.Errorf("response is signed by key '%s' instead of '%s'", keyID, r.KeyID)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(resp.Header.Get("X-Signature"))
+	if err != nil || len(signature) == 0 {
+		return errors.New("response has no valid signature header")
+	}
+
+	responseTime := resp.Header.Get("X-Response-Time")
+	var t time.Time
+	t, err = time.Parse(time.RFC3339, responseTime)
+	if err != nil {
+		return errors.Wrap(err, "cannot parse response time")
+	}
+	if math.Abs(float64(time.Since(t))) >= float64(requestTimeWindow) {
+		return errors.New("response time deviates too far")
+	}
+
+	var message []byte
+	message, err = json.Marshal(nodeResponseEnvelope{
+		RequestID:   requestID,
+		Path:        endpoint,
+		StatusCode:  resp.StatusCode,
+		CurrentTime: responseTime,
+		Body:        body,
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot create response envelope")
+	}
+
+	if err = verifyMessage(message, signature, resp.TLS.PeerCertificates[0].PublicKey); err != nil {
+		return errors.Wrap(err, "invalid response signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// signedResponse is a response of a node as received by the controller.
+type signedResponse struct {
+	resp      *http.Response
+	body      []byte
+	requestID string
+	endpoint  string
+}
+
+func newSignedResponse(t *testing.T, p *program, remote Remote) *signedResponse {
+	t.Helper()
+
+	req, requestID, err := p.newNodeRequest(context.Background(), remote, "/node/health", &nodeHealthAction{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := serveRequest(p.signResponses(p.nodeHealthHandler), req).Result()
+	resp.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{p.Config.selfCertificate.Leaf}}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d (%s), want %d", resp.StatusCode, body, http.StatusOK)
+	}
+
+	return &signedResponse{resp: resp, body: body, requestID: requestID, endpoint: "/node/health"}
+}
+
+func TestVerifyNodeResponse(t *testing.T) {
+	p := newTestNode(t)
+	other := newTestNode(t)
+	remote := Remote{Host: "127.0.0.1", KeyID: p.Config.selfKeyID}
+
+	tests := []struct {
+		name      string
+		remote    Remote
+		modify    func(sr *signedResponse)
+		wantValid bool
+	}{
+		{"signed response", remote, func(sr *signedResponse) {}, true},
+		{"tampered body", remote, func(sr *signedResponse) {
+			sr.body = []byte(`{"Status":"offline"}`)
+		}, false},
+		{"tampered status", remote, func(sr *signedResponse) {
+			sr.resp.StatusCode = http.StatusInternalServerError
+		}, false},
+		{"other request ID", remote, func(sr *signedResponse) {
+			sr.requestID = "other"
+		}, false},
+		{"other endpoint", remote, func(sr *signedResponse) {
+			sr.endpoint = "/node/execute/poweroff"
+		}, false},
+		{"unsigned", remote, func(sr *signedResponse) {
+			sr.resp.Header.Del("X-Signature")
+		}, false},
+		{"not over TLS", remote, func(sr *signedResponse) {
+			sr.resp.TLS = nil
+		}, false},
+		{"certificate of other key", remote, func(sr *signedResponse) {
+			sr.resp.TLS.PeerCertificates = []*x509.Certificate{other.Config.selfCertificate.Leaf}
+		}, false},
+		{"other key pinned", Remote{Host: "127.0.0.1", KeyID: other.Config.selfKeyID}, func(sr *signedResponse) {}, false},
+	}
+
+	for _, test := range tests {
+		sr := newSignedResponse(t, p, test.remote)
+		test.modify(sr)
+
+		err := verifyNodeResponse(test.remote, sr.requestID, sr.endpoint, sr.resp, sr.body)
+		if (err == nil) != test.wantValid {
+			t.Errorf("%s: got error %v, want valid %v", test.name, err, test.wantValid)
+		}
+	}
+}
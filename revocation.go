@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/pkg/errors"
+)
+
+const (
+	revocationsFileName = "revocations.json"
+
+	// Scope a key needs to sign and distribute revocation lists.
+	revokeScope = "revoke"
+)
+
+// revocationList lists the IDs of keys that must no longer be accepted, even
+// if they are still in the authorized keys directory somewhere. Every new list
+// of a signer has a higher version than its previous one.
+type revocationList struct {
+	Version  int          `json:"Version"`
+	IssuedAt string       `json:"IssuedAt"`
+	Revoked  []revokedKey `json:"Revoked"`
+}
+
+type revokedKey struct {
+	KeyID     string `json:"KeyID"`
+	Reason    string `json:"Reason"`
+	RevokedAt string `json:"RevokedAt"`
+}
+
+// signedRevocationList is how revocation lists are stored and distributed. The
+// list is signed separately from the request that carries it, so it stays
+// verifiable when forwarded.
+type signedRevocationList struct {
+	List      json.RawMessage `json:"List"`
+	KeyID     string          `json:"KeyID"`
+	Signature []byte          `json:"Signature"`
+}
+
+// revocationStore holds the newest revocation list of every signer known to this
+// host. Versions only have to increase per signer, so a list with a huge version
+// cannot block the lists of other signers. A key is revoked while the newest
+// list of any signer names it.
+type revocationStore struct {
+	mu sync.RWMutex
+
+	// Newest list by signer key ID.
+	signed map[string]signedRevocationList
+	lists  map[string]revocationList
+
+	revoked map[string]bool
+}
+
+func newRevocationStore() *revocationStore {
+	return &revocationStore{
+		signed:  make(map[string]signedRevocationList),
+		lists:   make(map[string]revocationList),
+		revoked: make(map[string]bool),
+	}
+}
+
+// loadRevocations reads the stored revocation lists. They were verified when
+// they were received, and their signers may have been rotated away since, so
+// they are trusted like the authorized keys directory.
+func loadRevocations() (*revocationStore, error) {
+	s := newRevocationStore()
+
+	b, err := os.ReadFile(revocationsFileName)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read '%s'", revocationsFileName)
+	}
+
+	var signedLists []signedRevocationList
+	if err = json.Unmarshal(b, &signedLists); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode '%s'", revocationsFileName)
+	}
+
+	for _, signed := range signedLists {
+		var list revocationList
+		if err = json.Unmarshal(signed.List, &list); err != nil {
+			return nil, errors.Wrapf(err, "cannot decode list in '%s'", revocationsFileName)
+		}
+
+		if list.Version > s.lists[signed.KeyID].Version {
+			s.set(signed, list)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *revocationStore) set(signed signedRevocationList, list revocationList) {
+	s.signed[signed.KeyID] = signed
+	s.lists[signed.KeyID] = list
+
+	s.revoked = make(map[string]bool)
+	for _, l := range s.lists {
+		for _, rk := range l.Revoked {
+			s.revoked[rk.KeyID] = true
+		}
+	}
+}
+
+// Versions returns the version of the newest list per signer key ID.
+func (s *revocationStore) Versions() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions := make(map[string]int, len(s.lists))
+	for keyID, list := range s.lists {
+		versions[keyID] = list.Version
+	}
+
+	return versions
+}
+
+// VersionBy returns the version of the newest list signed by keyID, or 0.
+func (s *revocationStore) VersionBy(keyID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lists[keyID].Version
+}
+
+func (s *revocationStore) IsRevoked(keyID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.revoked[keyID]
+}
+
+// All returns the newest list of every signer, ordered by signer key ID.
+func (s *revocationStore) All() []signedRevocationList {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.all()
+}
+
+func (s *revocationStore) all() []signedRevocationList {
+	keyIDs := make([]string, 0, len(s.signed))
+	for keyID := range s.signed {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+
+	signedLists := make([]signedRevocationList, 0, len(keyIDs))
+	for _, keyID := range keyIDs {
+		signedLists = append(signedLists, s.signed[keyID])
+	}
+
+	return signedLists
+}
+
+// Revoked returns the keys revoked by the newest lists of all signers, once per
+// key.
+func (s *revocationStore) Revoked() []revokedKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var revokedKeys []revokedKey
+	seen := make(map[string]bool)
+	for _, signed := range s.all() {
+		for _, rk := range s.lists[signed.KeyID].Revoked {
+			if !seen[rk.KeyID] {
+				seen[rk.KeyID] = true
+				revokedKeys = append(revokedKeys, rk)
+			}
+		}
+	}
+
+	return revokedKeys
+}
+
+// mayRevoke reports whether key may sign revocation lists. Unrestricted keys
+// may, and restricted keys must list revokeScope itself: "*" does not grant it.
+func mayRevoke(key authorizedKey) bool {
+	return key.Scopes == nil || key.Scopes[revokeScope]
+}
+
+// Apply verifies signed against the authorized keys and stores it if it is newer
+// than the stored list of its signer. Keys on the list are retired from keys
+// right away.
+func (s *revocationStore) Apply(signed signedRevocationList, keys *keyring) (bool, error) {
+	signer, ok := keys.Get(signed.KeyID)
+	if !ok || s.IsRevoked(signed.KeyID) {
+		return false, errors.Errorf("list is signed by unknown key '%s'", signed.KeyID)
+	}
+	if !mayRevoke(signer) {
+		return false, errors.Errorf("signer '%s' has no '%s' scope", signer.Path, revokeScope)
+	}
+	if err := verifyMessage(signed.List, signed.Signature, signer.Key); err != nil {
+		return false, errors.Wrap(err, "invalid list signature")
+	}
+
+	applied, err := s.store(signed)
+	if err != nil || !applied {
+		return false, err
+	}
+
+	for _, keyID := range s.revokedKeyIDs() {
+		if err = keys.Retire(keyID); err != nil {
+			return true, errors.Wrapf(err, "cannot retire revoked key '%s'", keyID)
+		}
+	}
+
+	return true, nil
+}
+
+// store replaces the list of the signer of signed if signed is newer, without
+// verifying its signature.
+func (s *revocationStore) store(signed signedRevocationList) (bool, error) {
+	var list revocationList
+	if err := json.Unmarshal(signed.List, &list); err != nil {
+		return false, errors.Wrap(err, "cannot decode list")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if list.Version <= s.lists[signed.KeyID].Version {
+		return false, nil
+	}
+
+	signedLists := s.all()
+	for i := range signedLists {
+		if signedLists[i].KeyID == signed.KeyID {
+			signedLists = append(signedLists[:i], signedLists[i+1:]...)
+			break
+		}
+	}
+	if err := writeRevocationLists(append(signedLists, signed)); err != nil {
+		return false, err
+	}
+
+	s.set(signed, list)
+	return true, nil
+}
+
+func (s *revocationStore) revokedKeyIDs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keyIDs := make([]string, 0, len(s.revoked))
+	for keyID := range s.revoked {
+		keyIDs = append(keyIDs, keyID)
+	}
+
+	return keyIDs
+}
+
+func signRevocationList(list revocationList, privateKey crypto.Signer, keyID string) (signedRevocationList, error) {
+	b, err := json.Marshal(list)
+	if err != nil {
+		return signedRevocationList{}, errors.Wrap(err, "cannot JSON marshall list")
+	}
+
+	var signature []byte
+	signature, err = signMessage(b, privateKey)
+	if err != nil {
+		return signedRevocationList{}, errors.Wrap(err, "cannot sign list")
+	}
+
+	return signedRevocationList{List: b, KeyID: keyID, Signature: signature}, nil
+}
+
+func writeRevocationLists(signedLists []signedRevocationList) (err error) {
+	var f *os.File
+	f, err = os.OpenFile(revocationsFileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create file '%s'", revocationsFileName)
+	}
+	defer func() {
+		err = firstError(err, errors.Wrapf(f.Close(), "cannot close file '%s'", revocationsFileName))
+	}()
+
+	// No indentation: it would change the signed bytes of the lists.
+	if err = json.NewEncoder(f).Encode(signedLists); err != nil {
+		return errors.Wrap(err, "cannot encode revocation lists JSON")
+	}
+
+	return
+}
+
+// revokeKey adds keyID to a new version of the revocation list, signs it with
+// the self key and distributes it to every remote.
+func revokeKey(keyID string, reason string) error {
+	c, err := loadConfig()
+	if err != nil {
+		return errors.Wrap(err, "cannot load config")
+	}
+
+	if keyID == c.selfKeyID {
+		return errors.New("cannot revoke the self key; use --rotate-key instead")
+	}
+
+	if c.revocations.IsRevoked(keyID) {
+		fmt.Printf("Key %s is already revoked; distributing the revocation lists again.\n", keyID)
+	} else {
+		now := time.Now().Format(time.RFC3339)
+		list := revocationList{
+			Version:  c.revocations.VersionBy(c.selfKeyID) + 1,
+			IssuedAt: now,
+			Revoked:  append(c.revocations.Revoked(), revokedKey{KeyID: keyID, Reason: reason, RevokedAt: now}),
+		}
+
+		var signed signedRevocationList
+		signed, err = signRevocationList(list, c.selfPrivateKey, c.selfKeyID)
+		if err != nil {
+			return err
+		}
+
+		if _, err = c.revocations.store(signed); err != nil {
+			return errors.Wrap(err, "cannot store revocation list")
+		}
+		if err = c.authorizedKeys.Retire(keyID); err != nil {
+			return errors.Wrap(err, "cannot retire key locally")
+		}
+
+		fmt.Printf("Revoked key %s in list version %d.\n", keyID, list.Version)
+	}
+
+	p := &program{Logger: service.ConsoleLogger, Config: c}
+	failed := p.distributeRevocations(context.Background(), func(r Remote, err error) {
+		if err != nil {
+			fmt.Printf("  %s: failed: %v\n", r.Host, err)
+		} else {
+			fmt.Printf("  %s: received\n", r.Host)
+		}
+	})
+
+	if failed > 0 {
+		return errors.Errorf("%d of %d remotes did not receive the lists", failed, len(c.WebAdmin.Remotes))
+	}
+
+	return nil
+}
+
+// distributeRevocations sends the newest revocation list of every signer to
+// every remote, calls report with the outcome per remote and returns the number
+// of failures.
+func (p *program) distributeRevocations(ctx context.Context, report func(Remote, error)) int {
+	signedLists := p.Config.revocations.All()
+	errs := p.fanOut(ctx, p.Config.WebAdmin.Remotes, func(ctx context.Context, i int, remote Remote) error {
+		return p.sendRevocationLists(ctx, remote, signedLists)
+	})
+
+	failed := 0
+	for i, remote := range p.Config.WebAdmin.Remotes {
+		if errs[i] != nil {
+			failed++
+		}
+
+		report(remote, errs[i])
+	}
+
+	return failed
+}
+
+func (p *program) sendRevocationLists(ctx context.Context, remote Remote, signedLists []signedRevocationList) error {
+	if len(signedLists) == 0 {
+		return errors.New("no revocation list to distribute")
+	}
+
+	for _, signed := range signedLists {
+		if err := p.SendRemoteRevocations(ctx, remote, signed); err != nil {
+			return errors.WithMessagef(err, "list of key '%s'", signed.KeyID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto"
+	"net/http"
+	"testing"
+)
+
+func newTestRevocationList(t *testing.T, key crypto.Signer, version int, revoked ...string) signedRevocationList {
+	t.Helper()
+
+	list := revocationList{Version: version}
+	for _, keyID := range revoked {
+		list.Revoked = append(list.Revoked, revokedKey{KeyID: keyID})
+	}
+
+	signed, err := signRevocationList(list, key, testKeyID(t, key.Public()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestRevocationStoreNewestPerSigner(t *testing.T) {
+	chdirTemp(t)
+	a, b := newTestKey(t), newTestKey(t)
+	aKeyID, bKeyID := testKeyID(t, a.Public()), testKeyID(t, b.Public())
+
+	s := newRevocationStore()
+	steps := []struct {
+		signed signedRevocationList
+		want   bool
+	}{
+		{newTestRevocationList(t, a, 1, "SHA256:x"), true},
+		{newTestRevocationList(t, b, 1000, "SHA256:y"), true},
+		// A huge version of b does not block newer lists of a.
+		{newTestRevocationList(t, a, 2, "SHA256:x", "SHA256:z"), true},
+		{newTestRevocationList(t, a, 2, "SHA256:x"), false},
+		{newTestRevocationList(t, a, 1, "SHA256:x"), false},
+	}
+	for i, step := range steps {
+		applied, err := s.store(step.signed)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if applied != step.want {
+			t.Errorf("step %d: got applied %v, want %v", i, applied, step.want)
+		}
+	}
+
+	// Only the newest list per signer is kept, also on disk.
+	loaded, err := loadRevocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, store := range []*revocationStore{s, loaded} {
+		if got := len(store.All()); got != 2 {
+			t.Errorf("got %d lists, want 2", got)
+		}
+		if got := store.VersionBy(aKeyID); got != 2 {
+			t.Errorf("got version %d of a, want 2", got)
+		}
+		if got := store.VersionBy(bKeyID); got != 1000 {
+			t.Errorf("got version %d of b, want 1000", got)
+		}
+		for _, keyID := range []string{"SHA256:x", "SHA256:y", "SHA256:z"} {
+			if !store.IsRevoked(keyID) {
+				t.Errorf("key %s is not revoked", keyID)
+			}
+		}
+		if got := len(store.Revoked()); got != 3 {
+			t.Errorf("got %d revoked keys, want 3", got)
+		}
+	}
+}
+
+func TestRevocationStoreApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  map[string]bool
+		wantErr bool
+	}{
+		{"unrestricted signer", nil, false},
+		{"revoke scope", map[string]bool{revokeScope: true}, false},
+		{"wildcard scope", map[string]bool{"*": true}, true},
+		{"other scope", map[string]bool{"health": true}, true},
+	}
+
+	for _, test := range tests {
+		chdirTemp(t)
+		signer := newTestKey(t)
+		signerKeyID := testKeyID(t, signer.Public())
+		revoked := newTestKey(t)
+		revokedKeyID := testKeyID(t, revoked.Public())
+		keys := newKeyring(map[string]authorizedKey{
+			signerKeyID:  {Path: "signer.pub", Key: signer.Public(), Scopes: test.scopes},
+			revokedKeyID: {Path: "revoked.pub", Key: revoked.Public()},
+		})
+
+		s := newRevocationStore()
+		_, err := s.Apply(newTestRevocationList(t, signer, 1, revokedKeyID), keys)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+
+		_, stillAuthorized := keys.Get(revokedKeyID)
+		if stillAuthorized == !test.wantErr {
+			t.Errorf("%s: revoked key authorized: %v", test.name, stillAuthorized)
+		}
+	}
+}
+
+func TestRevocationStoreApplyUntrusted(t *testing.T) {
+	chdirTemp(t)
+	signer := newTestKey(t)
+	signerKeyID := testKeyID(t, signer.Public())
+	keys := newKeyring(map[string]authorizedKey{signerKeyID: {Path: "signer.pub", Key: signer.Public()}})
+
+	unknown := newTestRevocationList(t, newTestKey(t), 1, "SHA256:x")
+	tampered := newTestRevocationList(t, signer, 1, "SHA256:x")
+	tampered.List = []byte(`{"Version":2,"Revoked":[]}`)
+
+	s := newRevocationStore()
+	for name, signed := range map[string]signedRevocationList{"unknown signer": unknown, "tampered list": tampered} {
+		if _, err := s.Apply(signed, keys); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if len(s.All()) != 0 {
+		t.Errorf("stored %d untrusted lists", len(s.All()))
+	}
+}
+
+func TestRevocationStatus(t *testing.T) {
+	versions := map[string]int{"SHA256:a": 2, "SHA256:b": 1}
+
+	tests := []struct {
+		nhr  nodeHealthResponse
+		want string
+	}{
+		{nodeHealthResponse{Status: "offline"}, "unknown"},
+		{nodeHealthResponse{Status: "online", RevocationVersions: map[string]int{"SHA256:a": 2, "SHA256:b": 1}}, "up to date"},
+		{nodeHealthResponse{Status: "online", RevocationVersions: map[string]int{"SHA256:a": 3, "SHA256:b": 1, "SHA256:c": 1}}, "up to date"},
+		{nodeHealthResponse{Status: "online", RevocationVersions: map[string]int{"SHA256:a": 1, "SHA256:b": 1}}, "outdated (SHA256:a: version 1 of 2)"},
+		{nodeHealthResponse{Status: "online"}, "outdated (SHA256:a: version 0 of 2, SHA256:b: version 0 of 1)"},
+	}
+
+	for _, test := range tests {
+		if got := revocationStatus(test.nhr, versions); got != test.want {
+			t.Errorf("versions %v: got %q, want %q", test.nhr.RevocationVersions, got, test.want)
+		}
+	}
+}
+
+func TestNodeRevocationsHandler(t *testing.T) {
+	chdirTemp(t)
+	p := newTestNode(t)
+	other := newTestNode(t)
+	otherKeyID := other.Config.selfKeyID
+	p.Config.authorizedKeys.keys[otherKeyID] = authorizedKey{Path: "other.pub", Key: other.Config.selfPrivateKey.Public()}
+
+	if rec := serveNodeRequest(t, other, p.nodeHealthHandler, "/node/health", &nodeHealthAction{}); rec.Code != http.StatusOK {
+		t.Fatalf("before revocation: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	signed := newTestRevocationList(t, p.Config.selfPrivateKey, 1, otherKeyID)
+	for i := 0; i < 2; i++ {
+		rec := serveNodeRequest(t, p, p.nodeRevocationsHandler, "/node/revocations", &nodeRevocationsAction{List: signed})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d (%s), want %d", i+1, rec.Code, rec.Body.String(), http.StatusOK)
+		}
+	}
+
+	if rec := serveNodeRequest(t, other, p.nodeHealthHandler, "/node/health", &nodeHealthAction{}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("after revocation: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
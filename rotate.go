@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/kardianos/service"
+	"github.com/pkg/errors"
+)
+
+const (
+	selfPrivKeyNewName = selfPrivKeyName + ".new"
+	selfPubKeyNewName  = selfPubKeyName + ".new"
+
+	// Hosts of the remotes that accepted the new key of an unfinished rotation.
+	rotationAcceptedName = selfPrivKeyNewName + ".accepted"
+
+	// How long remotes keep accepting the old self key after a rotation.
+	keyRotationGracePeriod = 24 * time.Hour
+)
+
+// rotateSelfKey replaces the self key with a new key of the given type, or of
+// defaultKeyType when keyType is empty. The new public key is pushed to every
+// remote with a request signed by the old key. The self key is only replaced
+// once every remote has accepted the new key; until then the new key waits in
+// the self key directory and the next rotation resumes with it. A resumed
+// rotation skips the remotes that accepted the new key before, as they may
+// already have retired the old key.
+func rotateSelfKey(keyType string) error {
+	c, err := loadConfig()
+	if err != nil {
+		return errors.Wrap(err, "cannot load config")
+	}
+
+	privKeyPath := selfKeyDirName + string(os.PathSeparator) + selfPrivKeyName
+	pubKeyPath := selfKeyDirName + string(os.PathSeparator) + selfPubKeyName
+	newPrivKeyPath := selfKeyDirName + string(os.PathSeparator) + selfPrivKeyNewName
+	newPubKeyPath := selfKeyDirName + string(os.PathSeparator) + selfPubKeyNewName
+	acceptedPath := selfKeyDirName + string(os.PathSeparator) + rotationAcceptedName
+
+	var newKey crypto.Signer
+	if _, err = os.Stat(newPrivKeyPath); err == nil {
+		newKey, err = loadPrivateKey(newPrivKeyPath)
+		if err != nil {
+			return errors.Wrap(err, "cannot load new key of earlier rotation")
+		}
+		if keyType != "" && keyType != keyTypeOf(newKey) {
+			return errors.Errorf("new key of earlier rotation in '%s' is of type '%s', not '%s'; resume without a key type or remove it to start over", newPrivKeyPath, keyTypeOf(newKey), keyType)
+		}
+		fmt.Printf("Resuming earlier rotation with '%s'.\n", newPrivKeyPath)
+	} else {
+		if keyType == "" {
+			keyType = defaultKeyType
+		}
+		if err = os.Remove(acceptedPath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "cannot remove remotes of earlier rotation")
+		}
+
+		newKey, err = generateKey(keyType)
+		if err != nil {
+			return errors.Wrap(err, "cannot generate key")
+		}
+
+		var privateKeyBlock *pem.Block
+		privateKeyBlock, err = encodePrivateKeyBlock(newKey)
+		if err != nil {
+			return errors.Wrap(err, "cannot encode private key")
+		}
+
+		if err = writePEMFile(newPrivKeyPath, privateKeyBlock); err != nil {
+			return errors.Wrap(err, "cannot write private key")
+		}
+	}
+
+	var publicKeyBlock *pem.Block
+	publicKeyBlock, err = encodePublicKeyBlock(newKey.Public())
+	if err != nil {
+		return errors.Wrap(err, "cannot encode public key")
+	}
+
+	if err = writePEMFile(newPubKeyPath, publicKeyBlock); err != nil {
+		return errors.Wrap(err, "cannot write public key")
+	}
+
+	var newKeyID string
+	newKeyID, err = keyFingerprint(newKey.Public())
+	if err != nil {
+		return errors.Wrap(err, "cannot determine new key ID")
+	}
+
+	fmt.Printf("Rotating self key %s to %s.\n", c.selfKeyID, newKeyID)
+
+	var accepted map[string]bool
+	accepted, err = readAcceptedRemotes(acceptedPath)
+	if err != nil {
+		return err
+	}
+
+	p := &program{Logger: service.ConsoleLogger, Config: c}
+	newPublicKeyPEM := string(pem.EncodeToMemory(publicKeyBlock))
+	failed := 0
+	for _, remote := range c.WebAdmin.Remotes {
+		if accepted[remote.Host] {
+			fmt.Printf("  %s: accepted earlier\n", remote.Host)
+			continue
+		}
+
+		if err = p.RotateRemoteKey(context.Background(), remote, newPublicKeyPEM, keyRotationGracePeriod); err != nil {
+			failed++
+			fmt.Printf("  %s: failed: %v\n", remote.Host, err)
+			continue
+		}
+
+		fmt.Printf("  %s: accepted\n", remote.Host)
+		accepted[remote.Host] = true
+		if err = writeAcceptedRemotes(acceptedPath, accepted); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d of %d remotes did not accept the new key; kept it in '%s' for the next rotation", failed, len(c.WebAdmin.Remotes), newPrivKeyPath)
+	}
+
+	if err = os.Rename(newPubKeyPath, pubKeyPath); err != nil {
+		return errors.Wrap(err, "cannot replace public key")
+	}
+	if err = os.Rename(newPrivKeyPath, privKeyPath); err != nil {
+		return errors.Wrap(err, "cannot replace private key")
+	}
+	if err = os.Remove(acceptedPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "cannot remove remotes of finished rotation")
+	}
+
+	fmt.Printf("Self key replaced. Remotes retire the old key in %s; restart the cloudcontrol service before then.\n", keyRotationGracePeriod)
+	return nil
+}
+
+// readAcceptedRemotes returns the hosts recorded by writeAcceptedRemotes, or none
+// if the file at path does not exist.
+func readAcceptedRemotes(path string) (map[string]bool, error) {
+	accepted := make(map[string]bool)
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return accepted, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read file '%s'", path)
+	}
+
+	var hosts []string
+	if err = json.Unmarshal(b, &hosts); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode file '%s'", path)
+	}
+
+	for _, host := range hosts {
+		accepted[host] = true
+	}
+
+	return accepted, nil
+}
+
+func writeAcceptedRemotes(path string, accepted map[string]bool) error {
+	hosts := make([]string, 0, len(accepted))
+	for host := range accepted {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	b, err := json.Marshal(hosts)
+	if err != nil {
+		return errors.Wrap(err, "cannot encode accepted remotes")
+	}
+
+	if err = os.WriteFile(path, b, 0600); err != nil {
+		return errors.Wrapf(err, "cannot write file '%s'", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writePendingRotation creates a config with the given remotes and a new self
+// key of an unfinished rotation that the given hosts have accepted.
+func writePendingRotation(t *testing.T, remotes []Remote, accepted ...string) string {
+	t.Helper()
+
+	c := Config{}
+	c.WebAdmin.Remotes = remotes
+	if err := writeConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeNewSelfKey(keyTypeEd25519); err != nil {
+		t.Fatal(err)
+	}
+
+	newKey := newTestKey(t)
+	block, err := encodePrivateKeyBlock(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writePEMFile(filepath.Join(selfKeyDirName, selfPrivKeyNewName), block); err != nil {
+		t.Fatal(err)
+	}
+
+	acceptedHosts := make(map[string]bool)
+	for _, host := range accepted {
+		acceptedHosts[host] = true
+	}
+	if err = writeAcceptedRemotes(filepath.Join(selfKeyDirName, rotationAcceptedName), acceptedHosts); err != nil {
+		t.Fatal(err)
+	}
+
+	return testKeyID(t, newKey.Public())
+}
+
+func TestRotateSelfKeyResume(t *testing.T) {
+	chdirTemp(t)
+	newKeyID := writePendingRotation(t, []Remote{
+		{Host: "10.0.0.2", KeyID: "SHA256:a"},
+		{Host: "10.0.0.3", KeyID: "SHA256:b"},
+	}, "10.0.0.2", "10.0.0.3")
+
+	// Every remote accepted the new key before, so none is contacted again.
+	if err := rotateSelfKey(""); err != nil {
+		t.Fatal(err)
+	}
+
+	selfKey, err := loadSelfKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID := testKeyID(t, selfKey.Public()); keyID != newKeyID {
+		t.Errorf("got self key %s, want %s", keyID, newKeyID)
+	}
+
+	for _, name := range []string{selfPrivKeyNewName, selfPubKeyNewName, rotationAcceptedName} {
+		if _, err = os.Stat(filepath.Join(selfKeyDirName, name)); !os.IsNotExist(err) {
+			t.Errorf("%s of finished rotation still exists: %v", name, err)
+		}
+	}
+}
+
+func TestRotateSelfKeyResumeKeyType(t *testing.T) {
+	chdirTemp(t)
+	writePendingRotation(t, nil)
+
+	if err := rotateSelfKey(keyTypeRSA); err == nil {
+		t.Fatal("resume with other key type: expected error")
+	}
+	if _, err := os.Stat(filepath.Join(selfKeyDirName, selfPrivKeyNewName)); err != nil {
+		t.Errorf("new key of earlier rotation is gone: %v", err)
+	}
+
+	if err := rotateSelfKey(keyTypeEd25519); err != nil {
+		t.Errorf("resume with same key type: %v", err)
+	}
+}
+
+func TestAcceptedRemotesRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), rotationAcceptedName)
+
+	accepted, err := readAcceptedRemotes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accepted) != 0 {
+		t.Errorf("missing file: got %v, want none", accepted)
+	}
+
+	accepted["10.0.0.2"] = true
+	accepted["10.0.0.3"] = true
+	if err = writeAcceptedRemotes(path, accepted); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readAcceptedRemotes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, accepted) {
+		t.Errorf("got %v, want %v", got, accepted)
+	}
+}
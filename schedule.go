@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	scheduleActionPoweroffAllAndSelf = "poweroff-all-and-self"
+	scheduleActionPowerDown          = "power-down"
+
+	// Runs that are due longer ago than this, like after the controller was
+	// asleep, are skipped instead of run late.
+	maxScheduleDelay = 5 * time.Minute
+
+	// Number of upcoming runs per rule listed in the webadmin.
+	upcomingRunsPerRule = 3
+)
+
+// ScheduleRule runs a power action on remotes at the times of a cron expression.
+type ScheduleRule struct {
+	Name string
+
+	// Five-field cron expression like "0 23 * * *".
+	Cron string
+
+	// IANA time zone the cron expression is in. Defaults to the local time zone.
+	Timezone string
+
+	// "poweroff", "power-down" (the PowerAction of each remote), "reboot",
+	// "poweron", one of the sleep actions, or "poweroff-all-and-self".
+	Action string
+
+	// Hosts of the remotes to run the action on. Defaults to all remotes.
+	Remotes []string
+}
+
+// scheduledRule is a ScheduleRule ready to run.
+type scheduledRule struct {
+	ScheduleRule
+	cron     cronSchedule
+	location *time.Location
+	next     time.Time
+	skipNext bool
+}
+
+// upcomingRun describes a scheduled run in the webadmin.
+type upcomingRun struct {
+	Rule    string
+	Action  string
+	Remotes string
+	Time    time.Time
+	Skipped bool
+}
+
+// scheduler runs schedule rules until the program stops. The zero value has no
+// rules.
+type scheduler struct {
+	mu    sync.Mutex
+	rules []*scheduledRule
+
+	// Signalled when a rule is skipped, so the run loop recomputes its timer.
+	changed chan struct{}
+}
+
+func newScheduler(rules []ScheduleRule, now time.Time) (*scheduler, error) {
+	s := &scheduler{changed: make(chan struct{}, 1)}
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, errors.New("no Name set for schedule rule")
+		}
+		if seen[rule.Name] {
+			return nil, errors.Errorf("duplicate schedule rule '%s'", rule.Name)
+		}
+		seen[rule.Name] = true
+
+		cron, err := parseCron(rule.Cron)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid Cron for schedule rule '%s'", rule.Name)
+		}
+
+		location := time.Local
+		if rule.Timezone != "" {
+			location, err = time.LoadLocation(rule.Timezone)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid Timezone for schedule rule '%s'", rule.Name)
+			}
+		}
+
+		sr := &scheduledRule{ScheduleRule: rule, cron: cron, location: location}
+		sr.next = cron.Next(now.In(location))
+		if sr.next.IsZero() {
+			return nil, errors.Errorf("schedule rule '%s' never runs", rule.Name)
+		}
+		s.rules = append(s.rules, sr)
+	}
+
+	return s, nil
+}
+
+// Upcoming lists the next runs of every rule, earliest first.
+func (s *scheduler) Upcoming() []upcomingRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := make([]upcomingRun, 0)
+	for _, rule := range s.rules {
+		remotes := "all"
+		if len(rule.Remotes) > 0 {
+			remotes = strings.Join(rule.Remotes, ", ")
+		}
+
+		t := rule.next
+		for i := 0; i < upcomingRunsPerRule && !t.IsZero(); i++ {
+			runs = append(runs, upcomingRun{
+				Rule:    rule.Name,
+				Action:  rule.Action,
+				Remotes: remotes,
+				Time:    t,
+				Skipped: i == 0 && rule.skipNext,
+			})
+			t = rule.cron.Next(t)
+		}
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].Time.Before(runs[j].Time)
+	})
+
+	return runs
+}
+
+// SkipNext skips or unskips the next run of the named rule.
+func (s *scheduler) SkipNext(name string, skip bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rule := range s.rules {
+		if rule.Name == name {
+			rule.skipNext = skip
+			select {
+			case s.changed <- struct{}{}:
+			default:
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// due returns the rules whose next run has come and moves them to their
+// following run. Skipped and overdue runs are not returned.
+func (s *scheduler) due(now time.Time, skipped func(rule ScheduleRule, reason string)) []ScheduleRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rules []ScheduleRule
+	for _, rule := range s.rules {
+		if rule.next.IsZero() || rule.next.After(now) {
+			continue
+		}
+
+		switch {
+		case rule.skipNext:
+			skipped(rule.ScheduleRule, "skipped by request")
+			rule.skipNext = false
+		case now.Sub(rule.next) > maxScheduleDelay:
+			skipped(rule.ScheduleRule, "missed by "+now.Sub(rule.next).Truncate(time.Second).String())
+		default:
+			rules = append(rules, rule.ScheduleRule)
+		}
+
+		rule.next = rule.cron.Next(now.In(rule.location))
+	}
+
+	return rules
+}
+
+// nextWake returns the time of the earliest run, or the zero time if no rule
+// will run again.
+func (s *scheduler) nextWake() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, rule := range s.rules {
+		if !rule.next.IsZero() && (next.IsZero() || rule.next.Before(next)) {
+			next = rule.next
+		}
+	}
+
+	return next
+}
+
+// runScheduler runs the schedule rules until the program stops.
+func (p *program) runScheduler() error {
+	for {
+		wait := time.Hour
+		if next := p.scheduler.nextWake(); !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-p.t.Dying():
+			timer.Stop()
+			return nil
+		case <-p.scheduler.changed:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		rules := p.scheduler.due(time.Now(), func(rule ScheduleRule, reason string) {
+			_ = p.Logger.Warningf("not running schedule rule '%s': %s", rule.Name, reason)
+		})
+		for _, rule := range rules {
+			_ = p.Logger.Infof("running schedule rule '%s': %s", rule.Name, rule.Action)
+			p.runScheduleRule(rule)
+		}
+	}
+}
+
+// runScheduleRule runs the action of the rule and logs the results. Actions that
+// power remotes down follow the dependency order of the remotes.
+func (p *program) runScheduleRule(rule ScheduleRule) {
+	remotes := p.Config.WebAdmin.Remotes
+	if len(rule.Remotes) > 0 {
+		remotes = make([]Remote, 0, len(rule.Remotes))
+		for _, host := range rule.Remotes {
+			remote, _ := p.remoteByHost(host)
+			remotes = append(remotes, remote)
+		}
+	}
+
+	newContext := p.shutdownContext
+	if rule.Action == "reboot" || rule.Action == "poweron" {
+		newContext = p.fleetContext
+	}
+	ctx, cancelFunc := newContext(p.t.Context(nil))
+	defer cancelFunc()
+
+	var report bytes.Buffer
+	var err error
+	poweroffSelf := false
+	switch {
+	case rule.Action == scheduleActionPoweroffAllAndSelf:
+		var result poweroffAllResult
+		result, err = p.powerDownRemotesAndSelf(ctx, remotes, false)
+		if err != nil {
+			break
+		}
+
+		writeFleetReport(&report, result.All())
+		err = fleetError(result.All())
+		poweroffSelf = result.Self.Err == nil
+	case rule.Action == "reboot":
+		results := p.fanOutPower(ctx, remotes, func(ctx context.Context, r Remote) ([]hookResult, error) {
+			return p.RebootRemote(ctx, r, false)
+		})
+		writeFleetReport(&report, results)
+		err = fleetError(results)
+	case rule.Action == "poweron":
+		results := p.fanOutPower(ctx, remotes, func(ctx context.Context, r Remote) ([]hookResult, error) {
+			return nil, p.PoweronRemote(r)
+		})
+		writeFleetReport(&report, results)
+		err = fleetError(results)
+	default:
+		var levels [][]Remote
+		levels, err = shutdownLevels(p.Config.WebAdmin.Remotes, remotes)
+		if err != nil {
+			break
+		}
+
+		// Remotes that fail keep the remotes they depend on up. The others still
+		// go down as scheduled.
+		results := p.powerDownLevels(ctx, levels, rule.Action, false, true)
+		writeFleetReport(&report, results)
+		err = fleetError(results)
+	}
+
+	if report.Len() > 0 {
+		_ = p.Logger.Infof("results of schedule rule '%s':\n%s", rule.Name, report.String())
+	}
+	if err != nil {
+		_ = p.Logger.Error(errors.Wrapf(err, "schedule rule '%s' failed", rule.Name))
+	}
+
+	if poweroffSelf {
+		if err = p.ExecutePoweroff(); err != nil {
+			_ = p.Logger.Error(errors.Wrapf(err, "schedule rule '%s' cannot power off self", rule.Name))
+		}
+	}
+}
+
+func (p *program) validateScheduleRules() error {
+	for _, rule := range p.Config.WebAdmin.Schedules {
+		switch {
+		case rule.Action == "poweroff", rule.Action == scheduleActionPowerDown, rule.Action == "reboot",
+			rule.Action == "poweron", rule.Action == scheduleActionPoweroffAllAndSelf, isSleepAction(rule.Action):
+		default:
+			return errors.Errorf("unknown Action '%s' for schedule rule '%s'", rule.Action, rule.Name)
+		}
+
+		for _, host := range rule.Remotes {
+			if _, ok := p.remoteByHost(host); !ok {
+				return errors.Errorf("unknown remote '%s' in schedule rule '%s'", host, rule.Name)
+			}
+		}
+	}
+
+	_, err := newScheduler(p.Config.WebAdmin.Schedules, time.Now())
+	return err
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/kardianos/service"
 	"github.com/pkg/errors"
@@ -10,25 +11,55 @@ import (
 
 func main() {
 	var webadmin bool
+	dryRun := containsString(os.Args[1:], "--dry-run")
 	if len(os.Args) > 1 {
 		arg := os.Args[1]
 		if arg == "--help" {
 			fmt.Println("Help commands:")
 			fmt.Println()
-			fmt.Println("--create-config: create config file in current working directory")
-			fmt.Println("--add-remote <host>: add remote to the config file")
-			fmt.Println("--webadmin: allow users to connect to a web admin interface on port 2001")
+			fmt.Println("--create-config [ed25519|rsa]: create config file and self key in current working directory (default ed25519)")
+			fmt.Println("--add-remote <host> <key-id>: add remote to the config file, pinning the key ID printed by --fingerprint on the remote")
+			fmt.Println("--boot-sequence: power on remotes step by step, waiting for each step to report healthy")
+			fmt.Println("--dry-run: log and record power actions instead of executing them, can be combined with --webadmin")
+			fmt.Println("--fingerprint: print the key ID of the self key")
+			fmt.Println("--lock <name> [ttl] [reason]: refuse power actions that are not forced until released or the TTL (like 2h) has passed")
+			fmt.Println("--locks: list the maintenance locks of this node")
+			fmt.Println("--pair <host>: exchange keys with a remote that runs --pairing-mode, and add it to the config file")
+			fmt.Println("--pairing-mode: wait for a controller to pair using a one-time code")
+			fmt.Println("--poweron <host>...: send Wake-on-LAN packets to the given remotes")
+			fmt.Println("--poweron-all: send Wake-on-LAN packets to all remotes with a MAC address")
+			fmt.Println("--revoke <key-id> [reason]: revoke a key and distribute the revocation list to all remotes")
+			fmt.Println("--rotate-key [ed25519|rsa]: replace the self key and authorize the new key on all remotes (default ed25519), or resume an unfinished rotation")
+			fmt.Println("--unlock <name>: release a maintenance lock")
+			fmt.Println("--webadmin: allow users to connect to a web admin interface at https://<host>:2001/webadmin/")
+			fmt.Println()
+			fmt.Println("Keys in the authorized_keys directory may perform every action unless their PEM")
+			fmt.Println("block has a header like 'Scopes: health, poweroff' listing the allowed actions.")
+			fmt.Println("Revocation lists are only accepted from keys without that header and from keys that")
+			fmt.Println("list the scope 'revoke' explicitly; '*' does not include it.")
+			fmt.Println("Forcing a power action despite maintenance locks also needs the scope 'force'.")
+			fmt.Println("Named commands are allowed by the scope 'command:<name>' unless they list their own Scopes.")
 			return
 		}
 
 		if arg == "--create-config" {
+			keyType := defaultKeyType
+			if len(os.Args) > 2 {
+				keyType = os.Args[2]
+			}
+			if keyType != keyTypeEd25519 && keyType != keyTypeRSA {
+				fmt.Printf("unknown key type '%s'\n", keyType)
+				os.Exit(1)
+				return
+			}
+
 			if err := writeConfig(Config{}); err != nil {
 				fmt.Println(errors.Wrap(err, "cannot create config in current working directory"))
 				os.Exit(1)
 				return
 			}
 
-			if err := writeNewSelfKey(); err != nil {
+			if err := writeNewSelfKey(keyType); err != nil {
 				fmt.Println(errors.Wrap(err, "cannot write new key"))
 				os.Exit(1)
 				return
@@ -37,20 +68,170 @@ func main() {
 			return
 		}
 
-		if arg == "--webadmin" {
+		if arg == "--webadmin" || (arg == "--dry-run" && containsString(os.Args[2:], "--webadmin")) {
 			webadmin = true
 		}
 
-		if len(os.Args) > 2 {
-			if arg == "--add-remote" {
-				if err := addRemote(os.Args[2]); err != nil {
-					fmt.Println(errors.Wrap(err, "cannot add remote"))
-					os.Exit(1)
-					return
-				}
+		if arg == "--fingerprint" {
+			if err := printFingerprint(); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot print fingerprint"))
+				os.Exit(1)
+				return
+			}
+
+			return
+		}
+
+		if arg == "--rotate-key" {
+			var keyType string
+			if len(os.Args) > 2 {
+				keyType = os.Args[2]
+			}
+
+			if err := rotateSelfKey(keyType); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot rotate self key"))
+				os.Exit(1)
+				return
+			}
+
+			return
+		}
 
+		if arg == "--poweron-all" {
+			if err := poweronRemotes(nil); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot power on remotes"))
+				os.Exit(1)
 				return
 			}
+
+			return
+		}
+
+		if arg == "--boot-sequence" {
+			if err := runBootSequenceCommand(); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot boot remotes"))
+				os.Exit(1)
+				return
+			}
+
+			return
+		}
+
+		if arg == "--locks" {
+			if err := printLocks(); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot list locks"))
+				os.Exit(1)
+				return
+			}
+
+			return
+		}
+
+		if arg == "--lock" {
+			if len(os.Args) < 3 {
+				fmt.Println("usage: --lock <name> [ttl] [reason]")
+				os.Exit(1)
+				return
+			}
+
+			if err := runLockCommand(os.Args[2:]); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot lock"))
+				os.Exit(1)
+				return
+			}
+
+			return
+		}
+
+		if arg == "--unlock" {
+			if len(os.Args) != 3 {
+				fmt.Println("usage: --unlock <name>")
+				os.Exit(1)
+				return
+			}
+
+			if err := runUnlockCommand(os.Args[2]); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot unlock"))
+				os.Exit(1)
+				return
+			}
+
+			return
+		}
+
+		if arg == "--pairing-mode" {
+			if err := runPairingMode(); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot pair"))
+				os.Exit(1)
+				return
+			}
+
+			return
+		}
+
+		if arg == "--revoke" {
+			if len(os.Args) < 3 {
+				fmt.Println("usage: --revoke <key-id> [reason]")
+				os.Exit(1)
+				return
+			}
+
+			reason := strings.Join(os.Args[3:], " ")
+			if err := revokeKey(os.Args[2], reason); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot revoke key"))
+				os.Exit(1)
+				return
+			}
+
+			return
+		}
+
+		if arg == "--poweron" {
+			if len(os.Args) < 3 {
+				fmt.Println("usage: --poweron <host>...")
+				os.Exit(1)
+				return
+			}
+
+			if err := poweronRemotes(os.Args[2:]); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot power on remotes"))
+				os.Exit(1)
+				return
+			}
+
+			return
+		}
+
+		if arg == "--pair" {
+			if len(os.Args) != 3 {
+				fmt.Println("usage: --pair <host>")
+				os.Exit(1)
+				return
+			}
+
+			if err := pairWithRemote(os.Args[2]); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot pair"))
+				os.Exit(1)
+				return
+			}
+
+			return
+		}
+
+		if arg == "--add-remote" {
+			if len(os.Args) != 4 {
+				fmt.Println("usage: --add-remote <host> <key-id>")
+				os.Exit(1)
+				return
+			}
+
+			if err := addRemote(os.Args[2], os.Args[3]); err != nil {
+				fmt.Println(errors.Wrap(err, "cannot add remote"))
+				os.Exit(1)
+				return
+			}
+
+			return
 		}
 	}
 
@@ -95,6 +276,13 @@ func main() {
 		return
 	}
 
+	prg.Executor, err = newPowerExecutor(c, dryRun, logger)
+	if err != nil {
+		_ = prg.Logger.Error(errors.Wrap(err, "invalid config"))
+		os.Exit(1)
+		return
+	}
+
 	err = s.Run()
 	if err != nil {
 		_ = logger.Error(err)
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	remoteRequestTimeout = 5 * time.Second
+)
+
+// newSelfCertificate creates a self-signed certificate for the self key. Peers
+// pin the key rather than the certificate, so a new one is created on every start.
+func newSelfCertificate(c Config) (tls.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "cannot generate serial number")
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "cloudcontrol " + c.selfKeyID},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if _, ok := c.selfPrivateKey.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	for _, hostname := range c.Node.Hostnames {
+		if ip := net.ParseIP(hostname); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, hostname)
+		}
+	}
+
+	var der []byte
+	der, err = x509.CreateCertificate(rand.Reader, template, template, c.selfPrivateKey.Public(), c.selfPrivateKey)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "cannot create certificate")
+	}
+
+	var leaf *x509.Certificate
+	leaf, err = x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "cannot parse created certificate")
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  c.selfPrivateKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+// serverTLSConfig serves the self certificate. Client certificates are requested
+// but not required during the handshake, so that browsers can still reach the
+// webadmin. verifyNodeRequest decides what to do with them.
+func (p *program) serverTLSConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{p.Config.selfCertificate},
+		ClientAuth:   tls.RequestClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// remoteHTTPClient returns a client that only talks to a remote presenting a
+// certificate for the key ID pinned in r.
+func (p *program) remoteHTTPClient(r Remote, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   pinnedTLSConfig(r.KeyID, p.Config.selfCertificate),
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+func pinnedTLSConfig(keyID string, clientCertificate tls.Certificate) *tls.Config {
+	return &tls.Config{
+		// Remotes have self-signed certificates, so there is no CA to check them
+		// against. VerifyPeerCertificate checks the pinned key instead.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPinnedCertificate(rawCerts, keyID)
+		},
+		Certificates: []tls.Certificate{clientCertificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+func verifyPinnedCertificate(rawCerts [][]byte, keyID string) error {
+	if keyID == "" {
+		return errors.New("no key ID pinned for remote")
+	}
+	if len(rawCerts) == 0 {
+		return errors.New("remote presented no certificate")
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return errors.Wrap(err, "cannot parse remote certificate")
+	}
+
+	var certKeyID string
+	certKeyID, err = keyFingerprint(cert.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "cannot determine key ID of remote certificate")
+	}
+
+	if certKeyID != keyID {
+		return errors.Errorf("remote certificate has key ID '%s' but '%s' is pinned", certKeyID, keyID)
+	}
+
+	return nil
+}
+
+// clientCertificateKeyID returns the key ID of the TLS client certificate of r,
+// or an empty string if the client did not present one.
+func clientCertificateKeyID(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", nil
+	}
+
+	return keyFingerprint(r.TLS.PeerCertificates[0].PublicKey)
+}
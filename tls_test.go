@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPinnedTLSConfig(t *testing.T) {
+	server := newTestNode(t)
+	client := newTestNode(t)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = server.serverTLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		keyID   string
+		wantErr bool
+	}{
+		{"pinned key", server.Config.selfKeyID, false},
+		{"other key", client.Config.selfKeyID, true},
+		{"nothing pinned", "", true},
+	}
+
+	for _, test := range tests {
+		c := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   pinnedTLSConfig(test.keyID, client.Config.selfCertificate),
+			DisableKeepAlives: true,
+		}}
+
+		resp, err := c.Get(ts.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestVerifyNodeRequestClientCertificate(t *testing.T) {
+	other := newTestNode(t)
+
+	tests := []struct {
+		name     string
+		cert     func(p *program) *tls.Certificate
+		required bool
+		want     int
+	}{
+		{"no certificate", nil, false, http.StatusOK},
+		{"no certificate but required", nil, true, http.StatusUnauthorized},
+		{"certificate of signing key", func(p *program) *tls.Certificate {
+			return &p.Config.selfCertificate
+		}, true, http.StatusOK},
+		{"certificate of other key", func(*program) *tls.Certificate {
+			return &other.Config.selfCertificate
+		}, false, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		p := newTestNode(t)
+		p.Config.Node.RequireClientCertificate = test.required
+		req, _, err := p.newNodeRequest(context.Background(), Remote{Host: "127.0.0.1"}, "/node/health", &nodeHealthAction{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.cert != nil {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.cert(p).Leaf}}
+		}
+
+		if rec := serveRequest(p.nodeHealthHandler, req); rec.Code != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, rec.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWakeOnLANAddress = "255.255.255.255:9"
+	defaultWakeOnLANPort    = "9"
+)
+
+// magicPacket returns a Wake-on-LAN packet: six 0xFF bytes followed by the MAC
+// address repeated sixteen times.
+func magicPacket(mac net.HardwareAddr) []byte {
+	packet := bytes.Repeat([]byte{0xFF}, 6)
+	packet = append(packet, bytes.Repeat(mac, 16)...)
+	return packet
+}
+
+func parseWakeMAC(s string) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse MAC address '%s'", s)
+	}
+	if len(mac) != 6 {
+		return nil, errors.Errorf("MAC address '%s' is not 6 bytes long", s)
+	}
+
+	return mac, nil
+}
+
+// PoweronRemote wakes the remote by sending a magic packet for its MAC address.
+func (p *program) PoweronRemote(r Remote) error {
+	if r.MAC == "" {
+		return errors.Errorf("no MAC address configured for remote '%s'", r.Host)
+	}
+
+	mac, err := parseWakeMAC(r.MAC)
+	if err != nil {
+		return err
+	}
+
+	var laddr, raddr *net.UDPAddr
+	laddr, raddr, err = wakeAddresses(r.WakeBroadcast, r.WakeInterface)
+	if err != nil {
+		return err
+	}
+
+	var conn *net.UDPConn
+	conn, err = net.DialUDP("udp4", laddr, raddr)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open UDP socket to '%s'", raddr)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if _, err = conn.Write(magicPacket(mac)); err != nil {
+		return errors.Wrapf(err, "cannot send magic packet to '%s'", raddr)
+	}
+
+	return nil
+}
+
+// wakeAddresses determines where to send a magic packet. With an interface, the
+// packet is sent from its IPv4 address to its broadcast address, unless an
+// explicit broadcast address is given. A broadcast address without a port gets
+// the discard port.
+func wakeAddresses(broadcast string, iface string) (*net.UDPAddr, *net.UDPAddr, error) {
+	var laddr *net.UDPAddr
+	if iface != "" {
+		ipNet, err := interfaceIPv4Net(iface)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		laddr = &net.UDPAddr{IP: ipNet.IP}
+		if broadcast == "" {
+			bcast := make(net.IP, len(ipNet.IP))
+			for i := range ipNet.IP {
+				bcast[i] = ipNet.IP[i] | ^ipNet.Mask[i]
+			}
+			broadcast = net.JoinHostPort(bcast.String(), defaultWakeOnLANPort)
+		}
+	}
+
+	if broadcast == "" {
+		broadcast = defaultWakeOnLANAddress
+	}
+	if _, _, err := net.SplitHostPort(broadcast); err != nil {
+		broadcast = net.JoinHostPort(broadcast, defaultWakeOnLANPort)
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp4", broadcast)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot resolve broadcast address '%s'", broadcast)
+	}
+
+	return laddr, raddr, nil
+}
+
+func interfaceIPv4Net(name string) (*net.IPNet, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot find interface '%s'", name)
+	}
+
+	var addrs []net.Addr
+	addrs, err = iface.Addrs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot list addresses of interface '%s'", name)
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		if ip4 := ipNet.IP.To4(); ip4 != nil && len(ipNet.Mask) == net.IPv4len {
+			return &net.IPNet{IP: ip4, Mask: ipNet.Mask}, nil
+		}
+	}
+
+	return nil, errors.Errorf("interface '%s' has no IPv4 address", name)
+}
+
+// poweronRemotes wakes the remotes with the given hosts, or every remote with a
+// MAC address when no hosts are given.
+func poweronRemotes(hosts []string) error {
+	c, err := loadConfig()
+	if err != nil {
+		return errors.Wrap(err, "cannot load config")
+	}
+
+	p := &program{Config: c}
+	if len(hosts) == 0 {
+		for _, remote := range c.WebAdmin.Remotes {
+			if remote.MAC != "" {
+				hosts = append(hosts, remote.Host)
+			}
+		}
+	}
+
+	for _, host := range hosts {
+		remote, ok := p.remoteByHost(host)
+		if !ok {
+			return errors.Errorf("unknown remote '%s'", host)
+		}
+
+		if err = p.PoweronRemote(remote); err != nil {
+			return errors.Wrapf(err, "cannot power on remote '%s'", host)
+		}
+	}
+
+	return nil
+}
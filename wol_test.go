@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoweronRemoteSendsMagicPacket(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	p := &program{}
+	remote := Remote{Host: "nas", MAC: "aa:bb:cc:dd:ee:ff", WakeBroadcast: conn.LocalAddr().String()}
+	if err = p.PoweronRemote(remote); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1024)
+	n, _, err := conn.ReadFromUDP(b)
+	if err != nil {
+		t.Fatalf("no magic packet received on %s: %v", conn.LocalAddr(), err)
+	}
+
+	mac := []byte{0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+	want := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	for i := 0; i < 16; i++ {
+		want = append(want, mac...)
+	}
+	if !bytes.Equal(b[:n], want) {
+		t.Fatalf("got packet % x, want % x", b[:n], want)
+	}
+}
+
+func TestWakeAddresses(t *testing.T) {
+	tests := []struct {
+		broadcast string
+		want      string
+	}{
+		{"", "255.255.255.255:9"},
+		{"192.168.1.255", "192.168.1.255:9"},
+		{"192.168.1.255:7", "192.168.1.255:7"},
+	}
+
+	for _, test := range tests {
+		laddr, raddr, err := wakeAddresses(test.broadcast, "")
+		if err != nil {
+			t.Fatalf("%q: %v", test.broadcast, err)
+		}
+		if laddr != nil {
+			t.Errorf("%q: got local address %s, want none", test.broadcast, laddr)
+		}
+		if raddr.String() != test.want {
+			t.Errorf("%q: got %s, want %s", test.broadcast, raddr, test.want)
+		}
+	}
+}
+
+func TestParseWakeMAC(t *testing.T) {
+	if _, err := parseWakeMAC("aa-bb-cc-dd-ee-ff"); err != nil {
+		t.Errorf("got error %v for a 6 byte MAC", err)
+	}
+	if _, err := parseWakeMAC("00:00:5e:00:53:01:02:03"); err == nil {
+		t.Error("got no error for an 8 byte MAC")
+	}
+	if _, err := parseWakeMAC("nas"); err == nil {
+		t.Error("got no error for an invalid MAC")
+	}
+}
+
+func TestWebadminExecutePoweronAllHandler(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	p := newTestNode(t)
+	p.Config.WebAdmin.Remotes = []Remote{
+		{Host: "broken", MAC: "nas"},
+		{Host: "nas", MAC: "aa:bb:cc:dd:ee:ff", WakeBroadcast: conn.LocalAddr().String()},
+		{Host: "printer"},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/webadmin/execute/poweron-all", nil)
+	rec := serveRequest(p.webadminExecutePoweronAllHandler, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	// The remote after the failing one is still woken.
+	body := rec.Body.String()
+	if !strings.Contains(body, "broken: failed") || !strings.Contains(body, "nas: OK") || strings.Contains(body, "printer") {
+		t.Errorf("unexpected report:\n%s", body)
+	}
+}